@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"mmm-osint/internal/pkg/queue"
+	"mmm-osint/internal/pkg/redisconn"
+)
+
+func main() {
+	queueName := flag.String("queue", string(queue.Investigate), "name of the queue to manage")
+	force := flag.Bool("force", false, "confirm destructive commands such as purge")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	q, err := queue.NewRedisQueueWithConfig[json.RawMessage](redisconn.ConfigFromEnv(), queue.QueueName(*queueName))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer q.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := run(ctx, q, flag.Arg(0), flag.Args()[1:], *force); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: queue-admin [flags] <command> [args]
+
+Commands:
+  stats        show length, in-flight count and oldest message age
+  length       show the number of ready and scheduled messages
+  peek [n]     show the next n messages (default 10)
+  consumers    list running consumers
+  purge        delete all ready and scheduled messages (requires -force)
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func run(ctx context.Context, admin queue.Admin, command string, args []string, force bool) error {
+	switch command {
+	case "stats":
+		return printStats(ctx, admin)
+	case "length":
+		length, err := admin.Length(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println(length)
+		return nil
+	case "peek":
+		n := 10
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+				return fmt.Errorf("invalid message count: %s", args[0])
+			}
+		}
+		return printPeek(ctx, admin, n)
+	case "consumers":
+		return printConsumers(ctx, admin)
+	case "purge":
+		if !force {
+			return fmt.Errorf("purge deletes every pending message, rerun with -force to confirm")
+		}
+		purged, err := admin.Purge(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("purged %d messages\n", purged)
+		return nil
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+}
+
+func printStats(ctx context.Context, admin queue.Admin) error {
+	length, err := admin.Length(ctx)
+	if err != nil {
+		return err
+	}
+	inFlight, err := admin.InFlight(ctx)
+	if err != nil {
+		return err
+	}
+	age, err := admin.OldestMessageAge(ctx)
+	if err != nil {
+		return err
+	}
+	consumers, err := admin.ListConsumers(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "length\t%d\n", length)
+	fmt.Fprintf(w, "in flight\t%d\n", inFlight)
+	fmt.Fprintf(w, "consumers\t%d\n", len(consumers))
+	fmt.Fprintf(w, "oldest message\t%s\n", age.Round(time.Second))
+	return w.Flush()
+}
+
+func printPeek(ctx context.Context, admin queue.Admin, n int) error {
+	envelopes, err := admin.Peek(ctx, n)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPRIORITY\tATTEMPT\tCREATED\tPRODUCER\tPAYLOAD")
+	for _, envelope := range envelopes {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			envelope.ID,
+			envelope.Priority,
+			envelope.Attempt,
+			envelope.CreatedAt.Format(time.RFC3339),
+			envelope.Producer,
+			truncate(string(envelope.Payload), 80),
+		)
+	}
+	return w.Flush()
+}
+
+func printConsumers(ctx context.Context, admin queue.Admin) error {
+	consumers, err := admin.ListConsumers(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tHOST\tSTARTED\tLAST SEEN\tIN FLIGHT")
+	for _, info := range consumers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n",
+			info.ID,
+			info.Host,
+			info.StartedAt.Format(time.RFC3339),
+			info.LastSeen.Format(time.RFC3339),
+			info.InFlight,
+		)
+	}
+	return w.Flush()
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-3]) + "..."
+}
@@ -2,41 +2,163 @@ package text_analysis
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
 
+	"mmm-osint/internal/pkg/cache"
 	"mmm-osint/internal/pkg/keyword"
 	"mmm-osint/internal/pkg/pii"
 )
 
+// AnalysisVersion is hashed into every cache key. Bump it whenever the
+// extractors change their output, so that older results are not reused.
+const AnalysisVersion = 1
+
+const cacheKeyPrefix = "text_analysis:"
+
+type Options struct {
+	// KeywordOptions defaults to keyword.DefaultOptions.
+	KeywordOptions *keyword.Options
+	// Cache memoizes results by a hash of the text, the keyword options and
+	// AnalysisVersion. The text itself is not stored, but the keywords are,
+	// and so are the PII entities found in it when CachePII is set. Nil
+	// disables caching.
+	Cache cache.Cache
+	// CacheTTL defaults to a day.
+	CacheTTL time.Duration
+	// CachePII memoizes the PII entities along with the keywords. They are
+	// stored in plain text, so leave it off when the cache is shared or
+	// persisted. Without it PII is extracted on every call.
+	CachePII bool
+}
+
+func (o *Options) withDefaults() Options {
+	options := Options{
+		KeywordOptions: keyword.DefaultOptions(),
+		CacheTTL:       24 * time.Hour,
+	}
+	if o != nil {
+		if o.KeywordOptions != nil {
+			// Copied so that later changes cannot desync results and keys.
+			keywordOptions := *o.KeywordOptions
+			options.KeywordOptions = &keywordOptions
+		}
+		options.Cache = o.Cache
+		if o.CacheTTL > 0 {
+			options.CacheTTL = o.CacheTTL
+		}
+		options.CachePII = o.CachePII
+	}
+	return options
+}
+
 type textAnalysisService struct {
 	piiExtractor     pii.Extractor
 	keywordExtractor keyword.Extractor
+	options          Options
+	loader           *cache.Loader
+	// keySeed is hashed ahead of the text in cache keys.
+	keySeed []byte
 }
 
 func NewTextAnalysisService(piiExtractor pii.Extractor, keywordExtractor keyword.Extractor) TextAnalysisService {
-	return &textAnalysisService{
+	return NewTextAnalysisServiceWithOptions(piiExtractor, keywordExtractor, nil)
+}
+
+func NewTextAnalysisServiceWithOptions(piiExtractor pii.Extractor, keywordExtractor keyword.Extractor, options *Options) TextAnalysisService {
+	s := &textAnalysisService{
 		piiExtractor:     piiExtractor,
 		keywordExtractor: keywordExtractor,
+		options:          options.withDefaults(),
 	}
+
+	if s.options.Cache != nil {
+		s.loader = cache.NewLoader(s.options.Cache, nil)
+		keywordOptions, _ := json.Marshal(s.options.KeywordOptions)
+		s.keySeed, _ = json.Marshal(map[string]any{
+			"version":         AnalysisVersion,
+			"keyword_options": json.RawMessage(keywordOptions),
+			"pii":             s.options.CachePII,
+		})
+	}
+
+	return s
 }
 
 func (s *textAnalysisService) AnalyzeText(ctx context.Context, text string) (*TextAnalysisResult, error) {
+	if s.loader == nil {
+		return s.analyze(ctx, text)
+	}
+
+	// The text is left out of cached results, which are keyed by it anyway.
+	var result TextAnalysisResult
+	err := s.loader.GetOrLoad(ctx, s.cacheKey(text), &result, s.options.CacheTTL, func(ctx context.Context) (any, error) {
+		analysis := &TextAnalysisResult{}
+		if s.options.CachePII {
+			if err := s.extractPII(ctx, text, analysis); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.extractKeywords(ctx, text, analysis); err != nil {
+			return nil, err
+		}
+		return analysis, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.options.CachePII {
+		if err := s.extractPII(ctx, text, &result); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Text = text
+	return &result, nil
+}
+
+func (s *textAnalysisService) cacheKey(text string) string {
+	hash := sha256.New()
+	hash.Write(s.keySeed)
+	hash.Write([]byte{0})
+	hash.Write([]byte(text))
+	return cacheKeyPrefix + hex.EncodeToString(hash.Sum(nil))
+}
+
+func (s *textAnalysisService) analyze(ctx context.Context, text string) (*TextAnalysisResult, error) {
 	result := &TextAnalysisResult{
 		Text: text,
 	}
 
+	if err := s.extractPII(ctx, text, result); err != nil {
+		return nil, err
+	}
+	if err := s.extractKeywords(ctx, text, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *textAnalysisService) extractPII(ctx context.Context, text string, result *TextAnalysisResult) error {
 	piiResult, err := s.piiExtractor.ExtractPII(ctx, text)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	result.PIIResult = piiResult
+	return nil
+}
 
-	keywords, err := s.keywordExtractor.ExtractKeywordsWithScores(ctx, text, keyword.DefaultOptions())
+func (s *textAnalysisService) extractKeywords(ctx context.Context, text string, result *TextAnalysisResult) error {
+	keywords, err := s.keywordExtractor.ExtractKeywordsWithScores(ctx, text, s.options.KeywordOptions)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	result.Keywords = keywords
-
-	return result, nil
+	return nil
 }
 
 func (s *textAnalysisService) Close() error {
@@ -3,11 +3,13 @@ package text_analysis_test
 import (
 	"context"
 	"errors"
+	"sync/atomic"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	"mmm-osint/internal/app/services/text_analysis"
+	"mmm-osint/internal/pkg/cache"
 	"mmm-osint/internal/pkg/keyword"
 	"mmm-osint/internal/pkg/pii"
 )
@@ -16,9 +18,11 @@ type mockPIIExtractor struct {
 	result     *pii.Result
 	shouldFail bool
 	closed     bool
+	calls      atomic.Int32
 }
 
 func (m *mockPIIExtractor) ExtractPII(ctx context.Context, text string) (*pii.Result, error) {
+	m.calls.Add(1)
 	if m.shouldFail {
 		return nil, errors.New("PII extraction failed")
 	}
@@ -34,6 +38,8 @@ type mockKeywordExtractor struct {
 	keywords   []keyword.Keyword
 	shouldFail bool
 	closed     bool
+	options    *keyword.Options
+	calls      atomic.Int32
 }
 
 func (m *mockKeywordExtractor) ExtractKeywords(ctx context.Context, text string, options *keyword.Options) ([]string, error) {
@@ -45,6 +51,8 @@ func (m *mockKeywordExtractor) ExtractKeywords(ctx context.Context, text string,
 }
 
 func (m *mockKeywordExtractor) ExtractKeywordsWithScores(ctx context.Context, text string, options *keyword.Options) ([]keyword.Keyword, error) {
+	m.options = options
+	m.calls.Add(1)
 	if m.shouldFail {
 		return nil, errors.New("keyword extraction failed")
 	}
@@ -146,6 +154,101 @@ var _ = Describe("TextAnalysisService", func() {
 		})
 	})
 
+	Describe("with a cache", func() {
+		var lruCache *cache.LRUCache
+
+		newService := func(options *keyword.Options) text_analysis.TextAnalysisService {
+			return text_analysis.NewTextAnalysisServiceWithOptions(piiExtractor, keywordExtractor, &text_analysis.Options{
+				KeywordOptions: options,
+				Cache:          lruCache,
+				CachePII:       true,
+			})
+		}
+
+		BeforeEach(func() {
+			var err error
+			lruCache, err = cache.NewLRUCache(10)
+			Expect(err).NotTo(HaveOccurred())
+
+			piiExtractor.result = &pii.Result{
+				Total:    1,
+				Entities: []pii.Entity{{Type: pii.PIITypeEmail, Value: "john.doe@example.com", Count: 1}},
+				Stats:    map[string]int{"email": 1},
+			}
+			keywordExtractor.keywords = []keyword.Keyword{{Text: "contact", Frequency: 1, Score: 0.8}}
+			service = newService(nil)
+		})
+
+		It("should analyze identical text once", func() {
+			text := "Contact john.doe@example.com"
+
+			first, err := service.AnalyzeText(ctx, text)
+			Expect(err).NotTo(HaveOccurred())
+			second, err := service.AnalyzeText(ctx, text)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(piiExtractor.calls.Load()).To(Equal(int32(1)))
+			Expect(second).To(Equal(first))
+			Expect(second.Text).To(Equal(text))
+			Expect(second.HasPII()).To(BeTrue())
+			Expect(second.Keywords).To(Equal(keywordExtractor.keywords))
+		})
+
+		It("should analyze different text again", func() {
+			_, err := service.AnalyzeText(ctx, "first page")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = service.AnalyzeText(ctx, "second page")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(piiExtractor.calls.Load()).To(Equal(int32(2)))
+		})
+
+		It("should key results by the keyword options", func() {
+			options := keyword.DefaultOptions()
+			_, err := newService(options).AnalyzeText(ctx, "page")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = newService(keyword.DefaultOptions()).AnalyzeText(ctx, "page")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(piiExtractor.calls.Load()).To(Equal(int32(1)))
+
+			options.MaxKeywords = 5
+			_, err = newService(options).AnalyzeText(ctx, "page")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(piiExtractor.calls.Load()).To(Equal(int32(2)))
+			Expect(keywordExtractor.options.MaxKeywords).To(Equal(5))
+		})
+
+		It("should not cache failed analyses", func() {
+			piiExtractor.shouldFail = true
+			_, err := service.AnalyzeText(ctx, "page")
+			Expect(err).To(HaveOccurred())
+
+			piiExtractor.shouldFail = false
+			result, err := service.AnalyzeText(ctx, "page")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.HasPII()).To(BeTrue())
+			Expect(piiExtractor.calls.Load()).To(Equal(int32(2)))
+		})
+
+		It("should only cache keywords unless asked to cache PII", func() {
+			service = text_analysis.NewTextAnalysisServiceWithOptions(piiExtractor, keywordExtractor, &text_analysis.Options{
+				Cache: lruCache,
+			})
+			text := "Contact john.doe@example.com"
+
+			for range 2 {
+				result, err := service.AnalyzeText(ctx, text)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.HasPII()).To(BeTrue())
+				Expect(result.Keywords).To(Equal(keywordExtractor.keywords))
+			}
+
+			Expect(keywordExtractor.calls.Load()).To(Equal(int32(1)))
+			Expect(piiExtractor.calls.Load()).To(Equal(int32(2)))
+		})
+	})
+
 	Describe("Close", func() {
 		It("should close both extractors without error", func() {
 			err := service.Close()
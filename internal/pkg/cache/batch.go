@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// manyDest returns the map dest points to for GetMany, allocating it if
+// needed.
+func manyDest(dest any) (reflect.Value, error) {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Map || v.Elem().Type().Key().Kind() != reflect.String {
+		return reflect.Value{}, &OpError{
+			Op:   "get",
+			Kind: ErrSerialization,
+			Err:  fmt.Errorf("dest must be a pointer to a map with string keys, got %T", dest),
+		}
+	}
+
+	values := v.Elem()
+	if values.IsNil() {
+		values.Set(reflect.MakeMap(values.Type()))
+	}
+	return values, nil
+}
+
+// putMany decodes data into values. Like decode, it returns ErrStale for
+// entries written in another format.
+func (f *valueFormat) putMany(values reflect.Value, key string, data []byte) error {
+	value := reflect.New(values.Type().Elem())
+	if err := f.decode(key, data, value.Interface()); err != nil {
+		return err
+	}
+
+	values.SetMapIndex(reflect.ValueOf(key).Convert(values.Type().Key()), value.Elem())
+	return nil
+}
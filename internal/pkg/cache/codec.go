@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"encoding/binary"
+
+	"mmm-osint/internal/pkg/serialize"
+)
+
+const (
+	DefaultCompressionThreshold = 1024
+
+	// formatMarker starts every stored value. It cannot start a JSON
+	// document, so values stored before the header existed are told apart.
+	formatMarker byte = 0xCA
+
+	flagGzip byte = 1 << 0
+)
+
+// Codec serializes cached values. Its content type is stored with every
+// value, so entries written with another codec are dropped instead of
+// misread.
+type Codec = serialize.Codec
+
+var (
+	JSONCodec        = serialize.JSON
+	GobCodec         = serialize.Gob
+	MessagePackCodec = serialize.MessagePack
+)
+
+// valueFormat is embedded by every cache to encode the values it stores.
+// Its setters must be called before the cache is used.
+type valueFormat struct {
+	codec                Codec
+	version              int
+	compressionThreshold int
+}
+
+func newValueFormat() valueFormat {
+	return valueFormat{
+		codec:                JSONCodec,
+		compressionThreshold: DefaultCompressionThreshold,
+	}
+}
+
+func (f *valueFormat) SetCodec(codec Codec) {
+	f.codec = codec
+}
+
+// SetCompressionThreshold gzips encoded values of at least threshold bytes.
+// Zero disables compression.
+func (f *valueFormat) SetCompressionThreshold(threshold int) {
+	f.compressionThreshold = threshold
+}
+
+// SetFormatVersion should be bumped whenever cached types change in a way
+// the codec cannot decode. Entries stored under another version are dropped.
+func (f *valueFormat) SetFormatVersion(version int) {
+	f.version = version
+}
+
+// encode serializes value behind a header made of the marker, the flags, the
+// format version and the codec content type.
+func (f *valueFormat) encode(key string, value any) ([]byte, error) {
+	payload, err := f.codec.Marshal(value)
+	if err != nil {
+		return nil, serializationError("set", key, err)
+	}
+
+	var flags byte
+	if f.compressionThreshold > 0 && len(payload) >= f.compressionThreshold {
+		if payload, err = serialize.Gzip(payload); err != nil {
+			return nil, serializationError("set", key, err)
+		}
+		flags |= flagGzip
+	}
+
+	name := f.codec.ContentType()
+	data := make([]byte, 0, 3+binary.MaxVarintLen64+len(name)+len(payload))
+	data = append(data, formatMarker, flags)
+	data = binary.AppendUvarint(data, uint64(f.version))
+	data = append(data, byte(len(name)))
+	data = append(data, name...)
+	return append(data, payload...), nil
+}
+
+// decode returns ErrStale when data was not written in the current format.
+func (f *valueFormat) decode(key string, data []byte, dest any) error {
+	payload, flags, ok := f.payload(data)
+	if !ok {
+		return ErrStale
+	}
+
+	if flags&flagGzip != 0 {
+		decompressed, err := serialize.Gunzip(payload)
+		if err != nil {
+			return serializationError("get", key, err)
+		}
+		payload = decompressed
+	}
+
+	if err := f.codec.Unmarshal(payload, dest); err != nil {
+		return serializationError("get", key, err)
+	}
+	return nil
+}
+
+func (f *valueFormat) payload(data []byte) ([]byte, byte, bool) {
+	if len(data) < 2 || data[0] != formatMarker {
+		return nil, 0, false
+	}
+	flags := data[1]
+	data = data[2:]
+
+	version, n := binary.Uvarint(data)
+	if n <= 0 || version != uint64(f.version) {
+		return nil, 0, false
+	}
+	data = data[n:]
+
+	name := f.codec.ContentType()
+	if len(data) < 1+len(name) || int(data[0]) != len(name) || string(data[1:1+len(name)]) != name {
+		return nil, 0, false
+	}
+	return data[1+len(name):], flags, true
+}
@@ -0,0 +1,104 @@
+package cache_test
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+
+	"mmm-osint/internal/pkg/cache"
+)
+
+var _ = Describe("Value format", func() {
+	type snapshot struct {
+		URL       string    `json:"url"`
+		FetchedAt time.Time `json:"fetched_at"`
+		Extra     any       `json:"extra"`
+	}
+
+	var (
+		lruCache *cache.LRUCache
+		ctx      context.Context
+	)
+
+	BeforeEach(func() {
+		var err error
+		lruCache, err = cache.NewLRUCache(10)
+		Expect(err).NotTo(HaveOccurred())
+		ctx = context.Background()
+	})
+
+	DescribeTable("should round-trip values with each codec",
+		func(codec cache.Codec) {
+			lruCache.SetCodec(codec)
+			value := snapshot{URL: "https://example.com", FetchedAt: time.Now().Truncate(time.Millisecond)}
+			Expect(lruCache.Set(ctx, "snapshot", value, 0)).To(Succeed())
+
+			var result snapshot
+			Expect(lruCache.Get(ctx, "snapshot", &result)).To(Succeed())
+			Expect(result.URL).To(Equal(value.URL))
+			Expect(result.FetchedAt).To(BeTemporally("==", value.FetchedAt))
+		},
+		Entry("JSON", cache.JSONCodec),
+		Entry("gob", cache.GobCodec),
+		Entry("MessagePack", cache.MessagePackCodec),
+	)
+
+	It("should keep the types of interface values with gob", func() {
+		lruCache.SetCodec(cache.GobCodec)
+		Expect(lruCache.Set(ctx, "snapshot", snapshot{Extra: 3}, 0)).To(Succeed())
+
+		var result snapshot
+		Expect(lruCache.Get(ctx, "snapshot", &result)).To(Succeed())
+		Expect(result.Extra).To(Equal(3))
+	})
+
+	It("should compress values above the threshold", func() {
+		html := strings.Repeat("<p>Lorem ipsum dolor sit amet</p>", 1000)
+
+		lruCache.SetCompressionThreshold(0)
+		Expect(lruCache.Set(ctx, "page", html, 0)).To(Succeed())
+		uncompressed := lruCache.Stats().Bytes
+		Expect(uncompressed).To(BeNumerically(">", len(html)))
+
+		lruCache.SetCompressionThreshold(cache.DefaultCompressionThreshold)
+		Expect(lruCache.Set(ctx, "page", html, 0)).To(Succeed())
+		Expect(lruCache.Stats().Bytes).To(BeNumerically("<", uncompressed/10))
+
+		var result string
+		Expect(lruCache.Get(ctx, "page", &result)).To(Succeed())
+		Expect(result).To(Equal(html))
+	})
+
+	It("should drop entries written with another codec", func() {
+		Expect(lruCache.Set(ctx, "key", "value", 0)).To(Succeed())
+		lruCache.SetCodec(cache.MessagePackCodec)
+
+		var result string
+		Expect(lruCache.Get(ctx, "key", &result)).To(Equal(cache.ErrStale))
+		Expect(lruCache.Get(ctx, "key", &result)).To(Equal(cache.ErrKeyNotFound))
+	})
+
+	It("should drop Redis values stored before the format header", func() {
+		server := miniredis.RunT(GinkgoT())
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		DeferCleanup(client.Close)
+		redisCache := cache.NewRedisCacheWithClient(client)
+
+		Expect(server.Set("legacy", `{"url":"https://example.com"}`)).To(Succeed())
+		Expect(server.Set("other", `"value"`)).To(Succeed())
+
+		var result snapshot
+		Expect(redisCache.Get(ctx, "legacy", &result)).To(MatchError(cache.ErrKeyNotFound))
+		Expect(server.Exists("legacy")).To(BeFalse())
+
+		results := map[string]string{}
+		Expect(redisCache.GetMany(ctx, []string{"other"}, &results)).To(Succeed())
+		Expect(results).To(BeEmpty())
+		Expect(server.Exists("other")).To(BeFalse())
+	})
+})
@@ -0,0 +1,285 @@
+package cache_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+
+	"mmm-osint/internal/pkg/cache"
+)
+
+// backend builds a cache for the conformance suite. advance moves the clock
+// of the cache forward so entries can expire.
+type backend struct {
+	newCache func() cache.Cache
+	advance  func(d time.Duration)
+}
+
+// Every cache.Cache implementation must pass this suite.
+var _ = Describe("Cache conformance", func() {
+	type record struct {
+		Name  string   `json:"name"`
+		Count int      `json:"count"`
+		Tags  []string `json:"tags"`
+	}
+
+	newRedisClient := func() (*miniredis.Miniredis, *redis.Client) {
+		server := miniredis.RunT(GinkgoT())
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		DeferCleanup(client.Close)
+		return server, client
+	}
+
+	backends := []struct {
+		name       string
+		newBackend func() backend
+	}{
+		{"LRUCache", func() backend {
+			return backend{
+				newCache: func() cache.Cache {
+					lruCache, err := cache.NewLRUCache(100)
+					Expect(err).NotTo(HaveOccurred())
+					return lruCache
+				},
+				advance: time.Sleep,
+			}
+		}},
+		{"DiskCache", func() backend {
+			dir := GinkgoT().TempDir()
+			return backend{
+				newCache: func() cache.Cache {
+					diskCache, err := cache.NewDiskCache(&cache.DiskConfig{Dir: dir})
+					Expect(err).NotTo(HaveOccurred())
+					return diskCache
+				},
+				advance: time.Sleep,
+			}
+		}},
+		{"RedisCache", func() backend {
+			server, client := newRedisClient()
+			return backend{
+				newCache: func() cache.Cache {
+					return cache.NewRedisCacheWithClient(client)
+				},
+				advance: server.FastForward,
+			}
+		}},
+		{"TieredCache", func() backend {
+			server, client := newRedisClient()
+			return backend{
+				newCache: func() cache.Cache {
+					l1, err := cache.NewLRUCache(100)
+					Expect(err).NotTo(HaveOccurred())
+					tiered, err := cache.NewTieredCache(l1, cache.NewRedisCacheWithClient(client), nil)
+					Expect(err).NotTo(HaveOccurred())
+					DeferCleanup(tiered.Close)
+					return tiered
+				},
+				advance: func(d time.Duration) {
+					time.Sleep(d)
+					server.FastForward(d)
+				},
+			}
+		}},
+	}
+
+	for _, entry := range backends {
+		Describe(entry.name, func() {
+			var (
+				b   backend
+				c   cache.Cache
+				ctx context.Context
+			)
+
+			BeforeEach(func() {
+				b = entry.newBackend()
+				c = b.newCache()
+				ctx = context.Background()
+			})
+
+			It("should round-trip values", func() {
+				value := record{Name: "example", Count: 3, Tags: []string{"a", "b"}}
+				Expect(c.Set(ctx, "record", value, 0)).To(Succeed())
+
+				var result record
+				Expect(c.Get(ctx, "record", &result)).To(Succeed())
+				Expect(result).To(Equal(value))
+				Expect(c.Exists(ctx, "record")).To(BeTrue())
+			})
+
+			It("should overwrite existing keys", func() {
+				Expect(c.Set(ctx, "key", "first", 0)).To(Succeed())
+				Expect(c.Set(ctx, "key", "second", 0)).To(Succeed())
+
+				var result string
+				Expect(c.Get(ctx, "key", &result)).To(Succeed())
+				Expect(result).To(Equal("second"))
+			})
+
+			It("should report a miss as ErrKeyNotFound", func() {
+				var result string
+				err := c.Get(ctx, "missing", &result)
+				Expect(err).To(MatchError(cache.ErrKeyNotFound))
+				Expect(c.Exists(ctx, "missing")).To(BeFalse())
+			})
+
+			It("should report an expired entry as a miss", func() {
+				Expect(c.Set(ctx, "key", "value", 50*time.Millisecond)).To(Succeed())
+				b.advance(100 * time.Millisecond)
+
+				var result string
+				Expect(c.Get(ctx, "key", &result)).To(MatchError(cache.ErrKeyNotFound))
+				Expect(c.Exists(ctx, "key")).To(BeFalse())
+			})
+
+			It("should delete keys and ignore missing ones", func() {
+				Expect(c.Set(ctx, "key", "value", 0)).To(Succeed())
+				Expect(c.Delete(ctx, "key")).To(Succeed())
+				Expect(c.Delete(ctx, "missing")).To(Succeed())
+
+				var result string
+				Expect(c.Get(ctx, "key", &result)).To(MatchError(cache.ErrKeyNotFound))
+			})
+
+			It("should get, set and delete many keys at once", func() {
+				Expect(c.SetMany(ctx, map[string]any{
+					"a": record{Name: "a", Count: 1},
+					"b": record{Name: "b", Count: 2},
+					"c": record{Name: "c", Count: 3},
+				}, 0)).To(Succeed())
+
+				var results map[string]record
+				Expect(c.GetMany(ctx, []string{"a", "b", "missing"}, &results)).To(Succeed())
+				Expect(results).To(Equal(map[string]record{
+					"a": {Name: "a", Count: 1},
+					"b": {Name: "b", Count: 2},
+				}))
+
+				Expect(c.DeleteMany(ctx, []string{"a", "c", "missing"})).To(Succeed())
+				results = nil
+				Expect(c.GetMany(ctx, []string{"a", "b", "c"}, &results)).To(Succeed())
+				Expect(results).To(HaveLen(1))
+				Expect(results).To(HaveKey("b"))
+			})
+
+			It("should leave expired keys out of GetMany", func() {
+				Expect(c.SetMany(ctx, map[string]any{"short": 1}, 50*time.Millisecond)).To(Succeed())
+				Expect(c.Set(ctx, "long", 2, 0)).To(Succeed())
+				b.advance(100 * time.Millisecond)
+
+				results := map[string]int{}
+				Expect(c.GetMany(ctx, []string{"short", "long"}, &results)).To(Succeed())
+				Expect(results).To(Equal(map[string]int{"long": 2}))
+			})
+
+			It("should require a map for GetMany", func() {
+				var result string
+				Expect(c.GetMany(ctx, []string{"key"}, &result)).To(MatchError(cache.ErrSerialization))
+			})
+
+			It("should delete keys by prefix", func() {
+				Expect(c.SetMany(ctx, map[string]any{
+					"page:example.com:/":      1,
+					"page:example.com:/about": 2,
+					"page:example.org:/":      3,
+					"page:example.com*:/":     4,
+				}, 0)).To(Succeed())
+
+				Expect(c.DeletePrefix(ctx, "page:example.com:")).To(Succeed())
+
+				Expect(c.Exists(ctx, "page:example.com:/")).To(BeFalse())
+				Expect(c.Exists(ctx, "page:example.com:/about")).To(BeFalse())
+				Expect(c.Exists(ctx, "page:example.org:/")).To(BeTrue())
+				Expect(c.Exists(ctx, "page:example.com*:/")).To(BeTrue())
+			})
+
+			It("should invalidate keys by tag", func() {
+				Expect(c.SetWithTags(ctx, "https://example.com/", 1, 0, []string{"domain:example.com"})).To(Succeed())
+				Expect(c.SetWithTags(ctx, "https://example.com/about", 2, 0, []string{"domain:example.com", "about"})).To(Succeed())
+				Expect(c.SetWithTags(ctx, "https://example.org/", 3, 0, []string{"domain:example.org"})).To(Succeed())
+
+				var value int
+				Expect(c.Get(ctx, "https://example.com/about", &value)).To(Succeed())
+				Expect(value).To(Equal(2))
+
+				Expect(c.InvalidateTag(ctx, "domain:example.com")).To(Succeed())
+				Expect(c.InvalidateTag(ctx, "unknown")).To(Succeed())
+
+				Expect(c.Exists(ctx, "https://example.com/")).To(BeFalse())
+				Expect(c.Exists(ctx, "https://example.com/about")).To(BeFalse())
+				Expect(c.Exists(ctx, "https://example.org/")).To(BeTrue())
+			})
+
+			It("should count hits and misses", func() {
+				Expect(c.Set(ctx, "a", 1, 0)).To(Succeed())
+
+				var value int
+				Expect(c.Get(ctx, "a", &value)).To(Succeed())
+				Expect(c.Get(ctx, "missing", &value)).NotTo(Succeed())
+
+				values := map[string]int{}
+				Expect(c.GetMany(ctx, []string{"a", "b"}, &values)).To(Succeed())
+
+				stats := c.Stats()
+				Expect(stats.Hits).To(Equal(int64(2)))
+				Expect(stats.Misses).To(Equal(int64(2)))
+				Expect(stats.HitRate()).To(Equal(0.5))
+			})
+
+			It("should drop entries written in another format version", func() {
+				Expect(c.Set(ctx, "key", "value", 0)).To(Succeed())
+				Expect(c.SetMany(ctx, map[string]any{"a": 1}, 0)).To(Succeed())
+				c.(interface{ SetFormatVersion(int) }).SetFormatVersion(2)
+
+				var result string
+				Expect(c.Get(ctx, "key", &result)).To(MatchError(cache.ErrStale))
+				Expect(c.Exists(ctx, "key")).To(BeFalse())
+
+				values := map[string]int{}
+				Expect(c.GetMany(ctx, []string{"a"}, &values)).To(Succeed())
+				Expect(values).To(BeEmpty())
+				Expect(c.Exists(ctx, "a")).To(BeFalse())
+
+				Expect(c.Set(ctx, "key", "current", 0)).To(Succeed())
+				Expect(c.Get(ctx, "key", &result)).To(Succeed())
+				Expect(result).To(Equal("current"))
+			})
+
+			It("should report values that cannot be encoded as ErrSerialization", func() {
+				err := c.Set(ctx, "key", make(chan int), 0)
+				Expect(err).To(MatchError(cache.ErrSerialization))
+
+				var opErr *cache.OpError
+				Expect(err).To(BeAssignableToTypeOf(opErr))
+				Expect(err.(*cache.OpError).Key).To(Equal("key"))
+			})
+
+			It("should report values that cannot be decoded as ErrSerialization", func() {
+				Expect(c.Set(ctx, "key", "text", 0)).To(Succeed())
+
+				var result int
+				Expect(c.Get(ctx, "key", &result)).To(MatchError(cache.ErrSerialization))
+			})
+		})
+	}
+
+	Describe("RedisCache without a reachable server", func() {
+		It("should report every operation as ErrUnavailable", func() {
+			server, client := newRedisClient()
+			redisCache := cache.NewRedisCacheWithClient(client)
+			server.Close()
+
+			ctx := context.Background()
+			var result string
+			Expect(redisCache.Set(ctx, "key", "value", 0)).To(MatchError(cache.ErrUnavailable))
+			Expect(redisCache.Get(ctx, "key", &result)).To(MatchError(cache.ErrUnavailable))
+			Expect(redisCache.Delete(ctx, "key")).To(MatchError(cache.ErrUnavailable))
+			_, err := redisCache.Exists(ctx, "key")
+			Expect(err).To(MatchError(cache.ErrUnavailable))
+		})
+	})
+})
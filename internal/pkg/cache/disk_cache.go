@@ -0,0 +1,585 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DiskConfig struct {
+	// Dir holds the cache in an entries subdirectory it owns, with one file
+	// per entry named after the SHA-256 of its key. Nothing else in Dir is
+	// touched.
+	Dir string
+	// MaxBytes caps the size of the entry files. The least recently used
+	// entries are evicted beyond it. Zero means no cap.
+	MaxBytes int64
+	// SweepInterval is how often Sweep runs. Zero disables it, leaving the
+	// files of expired entries on disk until they are read or evicted.
+	SweepInterval time.Duration
+	OnEvict       EvictionFunc
+}
+
+// diskEntry is the content of an entry file.
+type diskEntry struct {
+	Key        string    `json:"key"`
+	Expiration time.Time `json:"expiration"`
+	Tags       []string  `json:"tags,omitempty"`
+	Value      []byte    `json:"value"`
+}
+
+// diskItem is what the index keeps in memory about an entry file.
+type diskItem struct {
+	key        string
+	name       string
+	size       int64
+	expiration time.Time
+	tags       []string
+}
+
+func (i *diskItem) expired(now time.Time) bool {
+	return !i.expiration.IsZero() && now.After(i.expiration)
+}
+
+// DiskCache keeps entries in files so they survive restarts. Writes go to a
+// temporary file next to the entry that is synced and renamed into place, so
+// a crash leaves either the old entry or the new one. Recency survives
+// restarts through the modification time of the files.
+type DiskCache struct {
+	valueFormat
+
+	// dir is the entries subdirectory of the configured directory.
+	dir      string
+	maxBytes int64
+	mutex    sync.Mutex
+	items    map[string]*list.Element
+	order    *list.List
+	size     int64
+
+	onEvicted EvictionFunc
+	evicted   []eviction
+	counters  counters
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewDiskCache(config *DiskConfig) (*DiskCache, error) {
+	if config == nil || config.Dir == "" {
+		return nil, fmt.Errorf("disk cache directory is required")
+	}
+
+	d := &DiskCache{
+		valueFormat: newValueFormat(),
+		dir:         filepath.Join(config.Dir, "entries"),
+		maxBytes:    config.MaxBytes,
+		items:       make(map[string]*list.Element),
+		order:       list.New(),
+		onEvicted:   config.OnEvict,
+		stop:        make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(d.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create disk cache directory: %v", err)
+	}
+	d.mutex.Lock()
+	err := d.load()
+	d.unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load disk cache: %v", err)
+	}
+
+	if config.SweepInterval > 0 {
+		go d.sweep(config.SweepInterval)
+	}
+
+	return d, nil
+}
+
+// tmpPrefix starts the names of the temporary files of interrupted writes.
+const tmpPrefix = ".tmp-"
+
+func entryName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (d *DiskCache) path(name string) string {
+	return filepath.Join(d.dir, name[:2], name)
+}
+
+// load rebuilds the index from the entry files, dropping leftovers of
+// interrupted writes and entries that are expired or unreadable. Directories
+// that cannot be read are skipped.
+func (d *DiskCache) load() error {
+	type loaded struct {
+		item    *diskItem
+		modTime time.Time
+	}
+	var entries []loaded
+	now := time.Now()
+
+	err := filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == d.dir {
+				return err
+			}
+			log.Printf("Skipping unreadable disk cache path %s: %v", path, err)
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), tmpPrefix) {
+			os.Remove(path)
+			return nil
+		}
+		if !isEntryPath(path, entry.Name()) {
+			return nil
+		}
+
+		item, info, err := readDiskItem(path)
+		if err != nil || item.name != entry.Name() || item.expired(now) {
+			if err != nil {
+				log.Printf("Dropping unreadable disk cache entry %s: %v", path, err)
+			}
+			os.Remove(path)
+			return nil
+		}
+		entries = append(entries, loaded{item: item, modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.After(entries[j].modTime)
+	})
+	for _, entry := range entries {
+		d.items[entry.item.key] = d.order.PushBack(entry.item)
+		d.size += entry.item.size
+	}
+
+	d.evict()
+	return nil
+}
+
+// isEntryPath reports whether path is laid out like an entry file, so that
+// unrelated files in the directory are left alone.
+func isEntryPath(path string, name string) bool {
+	if len(name) != sha256.Size*2 || filepath.Base(filepath.Dir(path)) != name[:2] {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+func readDiskItem(path string) (*diskItem, os.FileInfo, error) {
+	entry, info, err := readDiskEntry(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &diskItem{
+		key:        entry.Key,
+		name:       entryName(entry.Key),
+		size:       info.Size(),
+		expiration: entry.Expiration,
+		tags:       entry.Tags,
+	}, info, nil
+}
+
+func readDiskEntry(path string) (*diskEntry, os.FileInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entry diskEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, nil, err
+	}
+	return &entry, info, nil
+}
+
+// write stores an entry file crash-safely. The caller holds d.mutex.
+func (d *DiskCache) write(entry *diskEntry) (*diskItem, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, serializationError("set", entry.Key, err)
+	}
+
+	name := entryName(entry.Key)
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, unavailableError("set", entry.Key, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), tmpPrefix+"*")
+	if err != nil {
+		return nil, unavailableError("set", entry.Key, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, unavailableError("set", entry.Key, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, unavailableError("set", entry.Key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, unavailableError("set", entry.Key, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, unavailableError("set", entry.Key, err)
+	}
+	syncDir(filepath.Dir(path))
+
+	return &diskItem{
+		key:        entry.Key,
+		name:       name,
+		size:       int64(len(data)),
+		expiration: entry.Expiration,
+		tags:       entry.Tags,
+	}, nil
+}
+
+// syncDir makes a rename durable. Not every platform supports syncing a
+// directory, so failures are ignored.
+func syncDir(dir string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	f.Sync()
+	f.Close()
+}
+
+// put stores an encoded value. The caller holds d.mutex.
+func (d *DiskCache) put(key string, data []byte, expiration time.Duration, tags []string) error {
+	entry := &diskEntry{
+		Key:   key,
+		Tags:  tags,
+		Value: data,
+	}
+	if expiration > 0 {
+		entry.Expiration = time.Now().Add(expiration)
+	}
+
+	item, err := d.write(entry)
+	if err != nil {
+		return err
+	}
+
+	if element, ok := d.items[key]; ok {
+		d.size -= element.Value.(*diskItem).size
+		element.Value = item
+		d.order.MoveToFront(element)
+	} else {
+		d.items[key] = d.order.PushFront(item)
+	}
+	d.size += item.size
+
+	d.evict()
+	return nil
+}
+
+// evict removes the least recently used entries until the cache fits in
+// maxBytes. The caller holds d.mutex.
+func (d *DiskCache) evict() {
+	if d.maxBytes <= 0 {
+		return
+	}
+	for d.size > d.maxBytes && d.order.Len() > 0 {
+		d.drop(d.order.Back(), EvictedForSpace)
+	}
+}
+
+// drop removes an entry that left the cache on its own. The caller holds
+// d.mutex.
+func (d *DiskCache) drop(element *list.Element, reason EvictionReason) {
+	key := element.Value.(*diskItem).key
+	d.remove(element)
+
+	d.counters.evicted(reason)
+	if d.onEvicted != nil {
+		d.evicted = append(d.evicted, eviction{key: key, reason: reason})
+	}
+}
+
+// unlock releases d.mutex, then runs the eviction callback.
+func (d *DiskCache) unlock() {
+	evicted := d.evicted
+	d.evicted = nil
+	d.mutex.Unlock()
+
+	for _, e := range evicted {
+		d.onEvicted(e.key, e.reason)
+	}
+}
+
+// remove deletes an entry file and drops it from the index. The caller holds
+// d.mutex.
+func (d *DiskCache) remove(element *list.Element) {
+	item := element.Value.(*diskItem)
+	if err := os.Remove(d.path(item.name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing disk cache entry %s: %v", item.key, err)
+	}
+
+	d.order.Remove(element)
+	delete(d.items, item.key)
+	d.size -= item.size
+}
+
+// lookup returns the live entry of key and marks it recently used. The
+// caller holds d.mutex.
+func (d *DiskCache) lookup(key string) (*diskItem, error) {
+	element, ok := d.items[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	item := element.Value.(*diskItem)
+	if item.expired(time.Now()) {
+		d.drop(element, EvictedExpired)
+		return nil, ErrExpired
+	}
+
+	d.order.MoveToFront(element)
+	now := time.Now()
+	os.Chtimes(d.path(item.name), now, now)
+
+	return item, nil
+}
+
+// read returns the encoded value of key. The caller holds d.mutex.
+func (d *DiskCache) read(key string) ([]byte, error) {
+	item, err := d.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, _, err := readDiskEntry(d.path(item.name))
+	if err != nil {
+		// The file is gone or damaged, so the entry is a miss from now on.
+		log.Printf("Dropping unreadable disk cache entry %s: %v", key, err)
+		d.remove(d.items[key])
+		return nil, ErrKeyNotFound
+	}
+	return entry.Value, nil
+}
+
+func (d *DiskCache) Set(ctx context.Context, key string, value any, expiration time.Duration) error {
+	return d.SetWithTags(ctx, key, value, expiration, nil)
+}
+
+func (d *DiskCache) SetWithTags(ctx context.Context, key string, value any, expiration time.Duration, tags []string) error {
+	data, err := d.encode(key, value)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.unlock()
+
+	return d.put(key, data, expiration, tags)
+}
+
+func (d *DiskCache) setEncoded(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	d.mutex.Lock()
+	defer d.unlock()
+
+	return d.put(key, data, expiration, nil)
+}
+
+func (d *DiskCache) Get(ctx context.Context, key string, dest any) error {
+	d.mutex.Lock()
+	defer d.unlock()
+
+	data, err := d.read(key)
+	if err == nil {
+		err = d.decode(key, data, dest)
+		if errors.Is(err, ErrStale) {
+			d.remove(d.items[key])
+		}
+	}
+
+	d.counters.read(!errors.Is(err, ErrKeyNotFound))
+	return err
+}
+
+func (d *DiskCache) Delete(ctx context.Context, key string) error {
+	return d.DeleteMany(ctx, []string{key})
+}
+
+func (d *DiskCache) Exists(ctx context.Context, key string) (bool, error) {
+	d.mutex.Lock()
+	defer d.unlock()
+
+	_, err := d.lookup(key)
+	return err == nil, nil
+}
+
+func (d *DiskCache) GetMany(ctx context.Context, keys []string, dest any) error {
+	values, err := manyDest(dest)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.unlock()
+
+	for _, key := range keys {
+		data, err := d.read(key)
+		if err == nil {
+			err = d.putMany(values, key, data)
+			if errors.Is(err, ErrStale) {
+				d.remove(d.items[key])
+			} else if err != nil {
+				return err
+			}
+		}
+		d.counters.read(err == nil)
+	}
+
+	return nil
+}
+
+func (d *DiskCache) SetMany(ctx context.Context, values map[string]any, expiration time.Duration) error {
+	encoded := make(map[string][]byte, len(values))
+	for key, value := range values {
+		data, err := d.encode(key, value)
+		if err != nil {
+			return err
+		}
+		encoded[key] = data
+	}
+
+	d.mutex.Lock()
+	defer d.unlock()
+
+	for key, data := range encoded {
+		if err := d.put(key, data, expiration, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *DiskCache) DeleteMany(ctx context.Context, keys []string) error {
+	d.mutex.Lock()
+	defer d.unlock()
+
+	for _, key := range keys {
+		if element, ok := d.items[key]; ok {
+			d.remove(element)
+		}
+	}
+
+	return nil
+}
+
+func (d *DiskCache) InvalidateTag(ctx context.Context, tag string) error {
+	d.mutex.Lock()
+	defer d.unlock()
+
+	d.removeWhere(func(item *diskItem) bool {
+		for _, t := range item.tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+func (d *DiskCache) DeletePrefix(ctx context.Context, prefix string) error {
+	d.mutex.Lock()
+	defer d.unlock()
+
+	d.removeWhere(func(item *diskItem) bool {
+		return strings.HasPrefix(item.key, prefix)
+	})
+	return nil
+}
+
+// removeWhere removes the entries matching match. The caller holds d.mutex.
+func (d *DiskCache) removeWhere(match func(item *diskItem) bool) {
+	for element := d.order.Front(); element != nil; {
+		next := element.Next()
+		if match(element.Value.(*diskItem)) {
+			d.remove(element)
+		}
+		element = next
+	}
+}
+
+// Sweep deletes the files of expired entries.
+func (d *DiskCache) Sweep() {
+	d.mutex.Lock()
+	defer d.unlock()
+
+	now := time.Now()
+	for element := d.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*diskItem).expired(now) {
+			d.drop(element, EvictedExpired)
+		}
+		element = next
+	}
+}
+
+func (d *DiskCache) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.Sweep()
+		}
+	}
+}
+
+func (d *DiskCache) Stats() Stats {
+	stats := d.counters.stats()
+
+	d.mutex.Lock()
+	stats.Entries = int64(d.order.Len())
+	stats.Bytes = d.size
+	d.mutex.Unlock()
+
+	return stats
+}
+
+// Close stops the background sweeper.
+func (d *DiskCache) Close() error {
+	d.stopOnce.Do(func() { close(d.stop) })
+	return nil
+}
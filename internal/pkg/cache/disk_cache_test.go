@@ -0,0 +1,179 @@
+package cache_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/cache"
+)
+
+var _ = Describe("Disk Cache", func() {
+	var (
+		dir string
+		ctx context.Context
+	)
+
+	open := func(maxBytes int64) *cache.DiskCache {
+		diskCache, err := cache.NewDiskCache(&cache.DiskConfig{Dir: dir, MaxBytes: maxBytes})
+		Expect(err).NotTo(HaveOccurred())
+		return diskCache
+	}
+
+	entryFiles := func() []string {
+		files, err := filepath.Glob(filepath.Join(dir, "entries", "??", "*"))
+		Expect(err).NotTo(HaveOccurred())
+		return files
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		ctx = context.Background()
+	})
+
+	It("should require a directory", func() {
+		_, err := cache.NewDiskCache(&cache.DiskConfig{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should keep entries across restarts", func() {
+		Expect(open(0).SetWithTags(ctx, "https://example.com", "page", time.Hour, []string{"domain:example.com"})).To(Succeed())
+
+		reopened := open(0)
+		var value string
+		Expect(reopened.Get(ctx, "https://example.com", &value)).To(Succeed())
+		Expect(value).To(Equal("page"))
+
+		Expect(reopened.InvalidateTag(ctx, "domain:example.com")).To(Succeed())
+		Expect(open(0).Exists(ctx, "https://example.com")).To(BeFalse())
+	})
+
+	It("should drop entries that expired while it was closed", func() {
+		Expect(open(0).Set(ctx, "key", "value", 50*time.Millisecond)).To(Succeed())
+		time.Sleep(100 * time.Millisecond)
+
+		Expect(open(0).Exists(ctx, "key")).To(BeFalse())
+		Expect(entryFiles()).To(BeEmpty())
+	})
+
+	It("should evict the least recently used entries beyond the size cap", func() {
+		diskCache := open(0)
+		Expect(diskCache.Set(ctx, "a", "value", 0)).To(Succeed())
+		info, err := os.Stat(entryFiles()[0])
+		Expect(err).NotTo(HaveOccurred())
+
+		diskCache = open(3 * info.Size())
+		Expect(diskCache.Set(ctx, "b", "value", 0)).To(Succeed())
+		Expect(diskCache.Set(ctx, "c", "value", 0)).To(Succeed())
+		Expect(diskCache.Exists(ctx, "a")).To(BeTrue())
+
+		Expect(diskCache.Set(ctx, "d", "value", 0)).To(Succeed())
+
+		Expect(diskCache.Exists(ctx, "b")).To(BeFalse())
+		Expect(diskCache.Exists(ctx, "a")).To(BeTrue())
+		Expect(diskCache.Exists(ctx, "c")).To(BeTrue())
+		Expect(diskCache.Exists(ctx, "d")).To(BeTrue())
+		Expect(entryFiles()).To(HaveLen(3))
+	})
+
+	It("should remember recency across restarts", func() {
+		diskCache := open(0)
+		for _, key := range []string{"a", "b", "c"} {
+			Expect(diskCache.Set(ctx, key, "value", 0)).To(Succeed())
+			time.Sleep(10 * time.Millisecond)
+		}
+		Expect(diskCache.Exists(ctx, "a")).To(BeTrue())
+		info, err := os.Stat(entryFiles()[0])
+		Expect(err).NotTo(HaveOccurred())
+
+		diskCache = open(2 * info.Size())
+
+		Expect(diskCache.Exists(ctx, "b")).To(BeFalse())
+		Expect(diskCache.Exists(ctx, "a")).To(BeTrue())
+		Expect(diskCache.Exists(ctx, "c")).To(BeTrue())
+	})
+
+	It("should clean up after interrupted writes and damaged entries", func() {
+		diskCache := open(0)
+		Expect(diskCache.Set(ctx, "good", "value", 0)).To(Succeed())
+		Expect(diskCache.Set(ctx, "damaged", "value", 0)).To(Succeed())
+
+		var damaged string
+		for _, file := range entryFiles() {
+			data, err := os.ReadFile(file)
+			Expect(err).NotTo(HaveOccurred())
+			if strings.HasPrefix(string(data), `{"key":"damaged"`) {
+				damaged = file
+			}
+		}
+		Expect(os.WriteFile(damaged, []byte(`{"key":"dam`), 0o644)).To(Succeed())
+		partial := filepath.Join(filepath.Dir(damaged), ".tmp-123")
+		Expect(os.WriteFile(partial, []byte("partial"), 0o644)).To(Succeed())
+
+		reopened := open(0)
+
+		var value string
+		Expect(reopened.Get(ctx, "good", &value)).To(Succeed())
+		Expect(reopened.Get(ctx, "damaged", &value)).To(MatchError(cache.ErrKeyNotFound))
+		Expect(partial).NotTo(BeAnExistingFile())
+		Expect(damaged).NotTo(BeAnExistingFile())
+	})
+
+	It("should leave the rest of its directory alone", func() {
+		notes := filepath.Join(dir, "notes.txt")
+		Expect(os.WriteFile(notes, []byte("keep me"), 0o644)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(dir, "tmp"), 0o755)).To(Succeed())
+		kept := filepath.Join(dir, "tmp", "data")
+		Expect(os.WriteFile(kept, []byte("keep me"), 0o644)).To(Succeed())
+		lookalike := filepath.Join(dir, "ab", "ab"+strings.Repeat("0", 62))
+		Expect(os.MkdirAll(filepath.Dir(lookalike), 0o755)).To(Succeed())
+		Expect(os.WriteFile(lookalike, []byte("keep me"), 0o644)).To(Succeed())
+
+		diskCache := open(0)
+		Expect(diskCache.Set(ctx, "key", "value", 0)).To(Succeed())
+		open(0)
+
+		Expect(notes).To(BeAnExistingFile())
+		Expect(kept).To(BeAnExistingFile())
+		Expect(lookalike).To(BeAnExistingFile())
+	})
+
+	It("should treat an entry file removed behind its back as a miss", func() {
+		diskCache := open(0)
+		Expect(diskCache.Set(ctx, "key", "value", 0)).To(Succeed())
+		Expect(os.Remove(entryFiles()[0])).To(Succeed())
+
+		var value string
+		Expect(diskCache.Get(ctx, "key", &value)).To(MatchError(cache.ErrKeyNotFound))
+		Expect(diskCache.Exists(ctx, "key")).To(BeFalse())
+	})
+
+	It("should report evictions and sweep expired entries", func() {
+		evictions := make(chan string, 10)
+		diskCache, err := cache.NewDiskCache(&cache.DiskConfig{
+			Dir:           dir,
+			SweepInterval: 10 * time.Millisecond,
+			OnEvict: func(key string, reason cache.EvictionReason) {
+				evictions <- key + ":" + reason.String()
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer diskCache.Close()
+
+		Expect(diskCache.Set(ctx, "short", "value", 20*time.Millisecond)).To(Succeed())
+		Expect(diskCache.Set(ctx, "long", "value", time.Hour)).To(Succeed())
+
+		Eventually(evictions).Should(Receive(Equal("short:expired")))
+		Expect(entryFiles()).To(HaveLen(1))
+
+		stats := diskCache.Stats()
+		Expect(stats.Entries).To(Equal(int64(1)))
+		Expect(stats.Expirations).To(Equal(int64(1)))
+		Expect(stats.Bytes).To(BeNumerically(">", 0))
+	})
+})
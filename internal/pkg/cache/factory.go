@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"mmm-osint/internal/pkg/env"
+	"mmm-osint/internal/pkg/redisconn"
+)
+
+type CacheType string
+
+const (
+	LRUCacheType    CacheType = "lru"
+	RedisCacheType  CacheType = "redis"
+	TieredCacheType CacheType = "tiered"
+	DiskCacheType   CacheType = "disk"
+)
+
+type TieredConfig struct {
+	LRU     *LRUConfig
+	Redis   *redisconn.Config
+	Options *TieredOptions
+}
+
+// NewCacheFromEnv creates the cache selected by CACHE_TYPE, an LRU cache by
+// default.
+func NewCacheFromEnv() (Cache, error) {
+	return NewCache(CacheType(env.GetOrDefault("CACHE_TYPE", string(LRUCacheType))))
+}
+
+// NewCache creates a cache configured from the environment, including the
+// format of its values: CACHE_CODEC, CACHE_COMPRESSION_THRESHOLD and
+// CACHE_FORMAT_VERSION.
+func NewCache(cacheType CacheType) (Cache, error) {
+	codec, err := codecFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newCacheFromEnv(cacheType)
+	if err != nil {
+		return nil, err
+	}
+
+	format := c.(formatSetter)
+	format.SetCodec(codec)
+	format.SetCompressionThreshold(env.GetIntOrDefault("CACHE_COMPRESSION_THRESHOLD", DefaultCompressionThreshold))
+	format.SetFormatVersion(env.GetIntOrDefault("CACHE_FORMAT_VERSION", 0))
+
+	return c, nil
+}
+
+// formatSetter is implemented by every cache of this package.
+type formatSetter interface {
+	SetCodec(codec Codec)
+	SetCompressionThreshold(threshold int)
+	SetFormatVersion(version int)
+}
+
+// codecNames are the values CACHE_CODEC accepts.
+var codecNames = map[string]Codec{
+	"json":    JSONCodec,
+	"gob":     GobCodec,
+	"msgpack": MessagePackCodec,
+}
+
+func codecFromEnv() (Codec, error) {
+	name := env.GetOrDefault("CACHE_CODEC", "json")
+	codec, ok := codecNames[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cache codec: %s", name)
+	}
+	return codec, nil
+}
+
+func newCacheFromEnv(cacheType CacheType) (Cache, error) {
+	switch cacheType {
+	case LRUCacheType:
+		return NewCacheWithConfig(cacheType, lruConfigFromEnv())
+	case RedisCacheType:
+		return NewCacheWithConfig(cacheType, redisconn.ConfigFromEnv())
+	case TieredCacheType:
+		return NewCacheWithConfig(cacheType, &TieredConfig{
+			LRU:   lruConfigFromEnv(),
+			Redis: redisconn.ConfigFromEnv(),
+			Options: &TieredOptions{
+				L1TTL:   env.GetDurationOrDefault("CACHE_L1_TTL", 0),
+				L2TTL:   env.GetDurationOrDefault("CACHE_L2_TTL", 0),
+				Channel: env.GetOrDefault("CACHE_INVALIDATION_CHANNEL", ""),
+			},
+		})
+	case DiskCacheType:
+		return NewCacheWithConfig(cacheType, &DiskConfig{
+			Dir:           env.GetOrDefault("CACHE_DISK_DIR", defaultDiskDir()),
+			MaxBytes:      int64(env.GetIntOrDefault("CACHE_DISK_MAX_BYTES", 1<<30)),
+			SweepInterval: env.GetDurationOrDefault("CACHE_SWEEP_INTERVAL", time.Minute),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported cache type: %s", cacheType)
+	}
+}
+
+func NewCacheWithConfig(cacheType CacheType, config any) (Cache, error) {
+	switch cacheType {
+	case LRUCacheType:
+		lruConfig, ok := config.(*LRUConfig)
+		if !ok {
+			return nil, fmt.Errorf("invalid config type for LRU cache, expected *LRUConfig")
+		}
+		return asCache(NewLRUCacheWithConfig(lruConfig))
+	case RedisCacheType:
+		redisConfig, ok := config.(*redisconn.Config)
+		if !ok {
+			return nil, fmt.Errorf("invalid config type for Redis cache, expected *redisconn.Config")
+		}
+		return asCache(NewRedisCacheWithConfig(redisConfig))
+	case TieredCacheType:
+		tieredConfig, ok := config.(*TieredConfig)
+		if !ok || tieredConfig.LRU == nil || tieredConfig.Redis == nil {
+			return nil, fmt.Errorf("invalid config type for tiered cache, expected *TieredConfig with LRU and Redis")
+		}
+		return asCache(newOwnedTieredCache(tieredConfig))
+	case DiskCacheType:
+		diskConfig, ok := config.(*DiskConfig)
+		if !ok {
+			return nil, fmt.Errorf("invalid config type for disk cache, expected *DiskConfig")
+		}
+		return asCache(NewDiskCache(diskConfig))
+	default:
+		return nil, fmt.Errorf("unsupported cache type: %s", cacheType)
+	}
+}
+
+// asCache keeps a failed constructor from returning a non-nil Cache holding a
+// nil pointer.
+func asCache[C Cache](c C, err error) (Cache, error) {
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newOwnedTieredCache(config *TieredConfig) (*TieredCache, error) {
+	l1, err := NewLRUCacheWithConfig(config.LRU)
+	if err != nil {
+		return nil, err
+	}
+	l2, err := NewRedisCacheWithConfig(config.Redis)
+	if err != nil {
+		l1.Close()
+		return nil, err
+	}
+
+	tiered, err := NewTieredCache(l1, l2, config.Options)
+	if err != nil {
+		l1.Close()
+		l2.Close()
+		return nil, err
+	}
+	tiered.ownsTiers = true
+
+	return tiered, nil
+}
+
+func lruConfigFromEnv() *LRUConfig {
+	return &LRUConfig{
+		Size:          env.GetIntOrDefault("CACHE_LRU_SIZE", 10000),
+		MaxBytes:      int64(env.GetIntOrDefault("CACHE_LRU_MAX_BYTES", 256<<20)),
+		SweepInterval: env.GetDurationOrDefault("CACHE_SWEEP_INTERVAL", time.Minute),
+	}
+}
+
+func defaultDiskDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "mmm-osint")
+}
@@ -0,0 +1,134 @@
+package cache_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/cache"
+	"mmm-osint/internal/pkg/redisconn"
+)
+
+var _ = Describe("Factory", func() {
+	Describe("NewCache", func() {
+		It("should create an LRU cache", func() {
+			c, err := cache.NewCache(cache.LRUCacheType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c).To(BeAssignableToTypeOf(&cache.LRUCache{}))
+		})
+
+		It("should create a disk cache in the configured directory", func() {
+			dir := GinkgoT().TempDir()
+			os.Setenv("CACHE_DISK_DIR", dir)
+			DeferCleanup(os.Unsetenv, "CACHE_DISK_DIR")
+
+			c, err := cache.NewCache(cache.DiskCacheType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c).To(BeAssignableToTypeOf(&cache.DiskCache{}))
+			Expect(c.Set(context.Background(), "key", "value", 0)).To(Succeed())
+		})
+
+		It("should create Redis backed caches from the Redis settings", func() {
+			server := miniredis.RunT(GinkgoT())
+			os.Setenv("REDIS_URI", server.Addr())
+			DeferCleanup(os.Unsetenv, "REDIS_URI")
+
+			c, err := cache.NewCache(cache.RedisCacheType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c).To(BeAssignableToTypeOf(&cache.RedisCache{}))
+			Expect(c.(io.Closer).Close()).To(Succeed())
+
+			c, err = cache.NewCache(cache.TieredCacheType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Set(context.Background(), "key", "value", 0)).To(Succeed())
+			Expect(server.Exists("key")).To(BeTrue())
+			Expect(c.(io.Closer).Close()).To(Succeed())
+		})
+
+		It("should select the type from CACHE_TYPE", func() {
+			os.Setenv("CACHE_TYPE", "disk")
+			os.Setenv("CACHE_DISK_DIR", GinkgoT().TempDir())
+			DeferCleanup(os.Unsetenv, "CACHE_TYPE")
+			DeferCleanup(os.Unsetenv, "CACHE_DISK_DIR")
+
+			c, err := cache.NewCacheFromEnv()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c).To(BeAssignableToTypeOf(&cache.DiskCache{}))
+		})
+
+		It("should configure the value format from the environment", func() {
+			os.Setenv("CACHE_CODEC", "msgpack")
+			os.Setenv("CACHE_FORMAT_VERSION", "3")
+			DeferCleanup(os.Unsetenv, "CACHE_CODEC")
+			DeferCleanup(os.Unsetenv, "CACHE_FORMAT_VERSION")
+
+			c, err := cache.NewCache(cache.LRUCacheType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Set(context.Background(), "key", "value", 0)).To(Succeed())
+
+			lruCache := c.(*cache.LRUCache)
+			lruCache.SetCodec(cache.JSONCodec)
+			var value string
+			Expect(c.Get(context.Background(), "key", &value)).To(MatchError(cache.ErrStale))
+		})
+
+		It("should reject unsupported codecs", func() {
+			os.Setenv("CACHE_CODEC", "xml")
+			DeferCleanup(os.Unsetenv, "CACHE_CODEC")
+
+			_, err := cache.NewCache(cache.LRUCacheType)
+			Expect(err).To(MatchError(ContainSubstring("unsupported cache codec")))
+		})
+
+		It("should reject unsupported types", func() {
+			c, err := cache.NewCache("memcached")
+			Expect(c).To(BeNil())
+			Expect(err).To(MatchError(ContainSubstring("unsupported cache type")))
+		})
+	})
+
+	Describe("NewCacheWithConfig", func() {
+		It("should use the given config", func() {
+			c, err := cache.NewCacheWithConfig(cache.DiskCacheType, &cache.DiskConfig{Dir: GinkgoT().TempDir()})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c).To(BeAssignableToTypeOf(&cache.DiskCache{}))
+		})
+
+		It("should reject a config of the wrong type", func() {
+			c, err := cache.NewCacheWithConfig(cache.LRUCacheType, &cache.DiskConfig{})
+			Expect(c).To(BeNil())
+			Expect(err).To(MatchError(ContainSubstring("expected *LRUConfig")))
+		})
+
+		It("should stop the local tier of a tiered cache when closed", func() {
+			server := miniredis.RunT(GinkgoT())
+
+			c, err := cache.NewCacheWithConfig(cache.TieredCacheType, &cache.TieredConfig{
+				LRU:   &cache.LRUConfig{Size: 10, SweepInterval: 10 * time.Millisecond},
+				Redis: &redisconn.Config{Addrs: []string{server.Addr()}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Set(context.Background(), "key", "value", 20*time.Millisecond)).To(Succeed())
+			Expect(c.(io.Closer).Close()).To(Succeed())
+
+			Consistently(func() int64 {
+				return c.Stats().Expirations
+			}, 100*time.Millisecond).Should(BeZero())
+		})
+
+		It("should return a nil cache when the backend cannot be created", func() {
+			server := miniredis.RunT(GinkgoT())
+			addr := server.Addr()
+			server.Close()
+
+			c, err := cache.NewCacheWithConfig(cache.RedisCacheType, &redisconn.Config{Addrs: []string{addr}})
+			Expect(err).To(HaveOccurred())
+			Expect(c == nil).To(BeTrue())
+		})
+	})
+})
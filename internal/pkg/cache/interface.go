@@ -3,14 +3,67 @@ package cache
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
-var ErrKeyNotFound = errors.New("key not found")
+// Every Cache reports failures through these errors, so errors.Is works the
+// same whatever the backend. A miss is ErrKeyNotFound. ErrExpired is a miss
+// too, returned by backends that can tell the entry expired. ErrStale is the
+// miss of an entry written in another format, which is dropped.
+var (
+	ErrKeyNotFound   = errors.New("key not found")
+	ErrExpired       = fmt.Errorf("%w: expired", ErrKeyNotFound)
+	ErrStale         = fmt.Errorf("%w: stale", ErrKeyNotFound)
+	ErrSerialization = errors.New("cache serialization failed")
+	ErrUnavailable   = errors.New("cache backend unavailable")
+)
 
 type Cache interface {
 	Set(ctx context.Context, key string, value any, expiration time.Duration) error
 	Get(ctx context.Context, key string, dest any) error
 	Delete(ctx context.Context, key string) error
 	Exists(ctx context.Context, key string) (bool, error)
+
+	// GetMany decodes the keys found into dest, a pointer to a map keyed by
+	// string. Missing keys are left out of the map.
+	GetMany(ctx context.Context, keys []string, dest any) error
+	SetMany(ctx context.Context, values map[string]any, expiration time.Duration) error
+	DeleteMany(ctx context.Context, keys []string) error
+
+	// SetWithTags stores value like Set and files key under each tag, so that
+	// InvalidateTag can drop every key of a tag at once.
+	SetWithTags(ctx context.Context, key string, value any, expiration time.Duration, tags []string) error
+	InvalidateTag(ctx context.Context, tag string) error
+	DeletePrefix(ctx context.Context, prefix string) error
+
+	Stats() Stats
+}
+
+// OpError describes a failed cache operation. It matches Kind, one of the
+// errors above, as well as the underlying error.
+type OpError struct {
+	Op   string
+	Key  string
+	Kind error
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("cache %s: %v: %v", e.Op, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("cache %s %s: %v: %v", e.Op, e.Key, e.Kind, e.Err)
+}
+
+func (e *OpError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+func serializationError(op, key string, err error) error {
+	return &OpError{Op: op, Key: key, Kind: ErrSerialization, Err: err}
+}
+
+func unavailableError(op, key string, err error) error {
+	return &OpError{Op: op, Key: key, Kind: ErrUnavailable, Err: err}
 }
@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// LoadFunc computes the value of a key missing from the cache.
+type LoadFunc func(ctx context.Context) (any, error)
+
+type LoaderOptions struct {
+	// Locker makes instances wait for the one already loading a key instead
+	// of loading it as well.
+	Locker Locker
+	// DistributedLock uses the Locker of a RedisCache, or of the second tier
+	// of a TieredCache, when no Locker is given.
+	DistributedLock bool
+	// LockTTL bounds how long a crashed instance can hold the lock of a key.
+	LockTTL time.Duration
+	// LockPollInterval is how often waiting instances check the cache.
+	LockPollInterval time.Duration
+	// LoadTimeout bounds a load. Loads are shared by every caller missing the
+	// key, so they go on when the caller that started them gives up.
+	LoadTimeout time.Duration
+}
+
+func (o *LoaderOptions) withDefaults() LoaderOptions {
+	options := LoaderOptions{
+		LockTTL:          30 * time.Second,
+		LockPollInterval: 50 * time.Millisecond,
+		LoadTimeout:      30 * time.Second,
+	}
+	if o != nil {
+		options.Locker = o.Locker
+		options.DistributedLock = o.DistributedLock
+		if o.LockTTL > 0 {
+			options.LockTTL = o.LockTTL
+		}
+		if o.LockPollInterval > 0 {
+			options.LockPollInterval = o.LockPollInterval
+		}
+		if o.LoadTimeout > 0 {
+			options.LoadTimeout = o.LoadTimeout
+		}
+	}
+	return options
+}
+
+type LoaderStats struct {
+	Hits       int64
+	Misses     int64
+	Loads      int64
+	LoadErrors int64
+	// Shared counts misses answered by a load another caller started.
+	Shared int64
+}
+
+type valueCodec interface {
+	encode(key string, value any) ([]byte, error)
+	decode(key string, data []byte, dest any) error
+}
+
+// encodedCache is implemented by the caches of this package. The Loader
+// encodes a loaded value once, in the format of the cache, to both store it
+// and hand it to every caller waiting for it.
+type encodedCache interface {
+	valueCodec
+	setEncoded(ctx context.Context, key string, data []byte, expiration time.Duration) error
+}
+
+// Loader wraps a Cache so that concurrent misses of the same key run the
+// loader once and share its result.
+type Loader struct {
+	cache   Cache
+	codec   valueCodec
+	options LoaderOptions
+	locker  Locker
+	group   singleflight.Group
+
+	hits       atomic.Int64
+	misses     atomic.Int64
+	loads      atomic.Int64
+	loadErrors atomic.Int64
+	shared     atomic.Int64
+}
+
+func NewLoader(cache Cache, options *LoaderOptions) *Loader {
+	l := &Loader{
+		cache:   cache,
+		options: options.withDefaults(),
+	}
+	if encoded, ok := cache.(encodedCache); ok {
+		l.codec = encoded
+	} else {
+		format := newValueFormat()
+		l.codec = &format
+	}
+	l.locker = l.options.Locker
+	if l.locker == nil && l.options.DistributedLock {
+		if c, ok := cache.(interface{ Locker() *RedisLocker }); ok {
+			l.locker = c.Locker()
+		}
+	}
+	return l
+}
+
+// GetOrLoad reads key into dest. On a miss it calls load, stores the result
+// for ttl and decodes it into dest. load gets the values of ctx but not its
+// cancellation, which only stops this caller from waiting for the result.
+func (l *Loader) GetOrLoad(ctx context.Context, key string, dest any, ttl time.Duration, load LoadFunc) error {
+	err := l.cache.Get(ctx, key, dest)
+	if err == nil {
+		l.hits.Add(1)
+		return nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		log.Printf("Error reading cache key %s, loading it instead: %v", key, err)
+	}
+	l.misses.Add(1)
+
+	ran := false
+	results := l.group.DoChan(key, func() (any, error) {
+		ran = true
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), l.options.LoadTimeout)
+		defer cancel()
+		return l.load(loadCtx, key, ttl, load)
+	})
+
+	var result singleflight.Result
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case result = <-results:
+	}
+	if result.Shared && !ran {
+		l.shared.Add(1)
+	}
+	if result.Err != nil {
+		return result.Err
+	}
+
+	// Another instance stored the key while this one waited for its lock.
+	data := result.Val.([]byte)
+	if data == nil {
+		return l.cache.Get(ctx, key, dest)
+	}
+	return l.codec.decode(key, data, dest)
+}
+
+func (l *Loader) load(ctx context.Context, key string, ttl time.Duration, load LoadFunc) ([]byte, error) {
+	if l.locker != nil {
+		lock, err := l.waitForLock(ctx, key)
+		if err != nil || lock == nil {
+			return nil, err
+		}
+		defer l.release(ctx, key, lock)
+	}
+
+	l.loads.Add(1)
+	value, err := load(ctx)
+	if err != nil {
+		l.loadErrors.Add(1)
+		return nil, err
+	}
+
+	data, err := l.codec.encode(key, value)
+	if err != nil {
+		l.loadErrors.Add(1)
+		return nil, err
+	}
+
+	if encoded, ok := l.cache.(encodedCache); ok {
+		err = encoded.setEncoded(ctx, key, data, ttl)
+	} else {
+		err = l.cache.Set(ctx, key, value, ttl)
+	}
+	if err != nil {
+		log.Printf("Error caching loaded key %s: %v", key, err)
+	}
+
+	return data, nil
+}
+
+// waitForLock takes the lock of key. It returns no lock when another
+// instance stores the key while this one waits.
+func (l *Loader) waitForLock(ctx context.Context, key string) (*Lock, error) {
+	ticker := time.NewTicker(l.options.LockPollInterval)
+	defer ticker.Stop()
+
+	for {
+		lock, err := l.locker.Acquire(ctx, key, l.options.LockTTL)
+		if err != nil && !errors.Is(err, ErrLockHeld) {
+			return nil, fmt.Errorf("failed to lock cache key %s: %v", key, err)
+		}
+
+		if stored, err := l.cache.Exists(ctx, key); err == nil && stored {
+			if lock != nil {
+				l.release(ctx, key, lock)
+			}
+			return nil, nil
+		}
+
+		if lock != nil {
+			return lock, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *Loader) release(ctx context.Context, key string, lock *Lock) {
+	if err := l.locker.Release(context.WithoutCancel(ctx), lock); err != nil {
+		log.Printf("Error releasing cache lock for key %s: %v", key, err)
+	}
+}
+
+func (l *Loader) Stats() LoaderStats {
+	return LoaderStats{
+		Hits:       l.hits.Load(),
+		Misses:     l.misses.Load(),
+		Loads:      l.loads.Load(),
+		LoadErrors: l.loadErrors.Load(),
+		Shared:     l.shared.Load(),
+	}
+}
@@ -0,0 +1,263 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+
+	"mmm-osint/internal/pkg/cache"
+)
+
+var _ = Describe("Loader", func() {
+	type page struct {
+		URL   string `json:"url"`
+		Title string `json:"title"`
+	}
+
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	Context("with an LRU cache", func() {
+		var (
+			lruCache *cache.LRUCache
+			loader   *cache.Loader
+		)
+
+		BeforeEach(func() {
+			var err error
+			lruCache, err = cache.NewLRUCache(10)
+			Expect(err).NotTo(HaveOccurred())
+			loader = cache.NewLoader(lruCache, nil)
+		})
+
+		It("should load a missing key and store it", func() {
+			var result page
+			err := loader.GetOrLoad(ctx, "page", &result, time.Minute, func(ctx context.Context) (any, error) {
+				return page{URL: "https://example.com", Title: "Example"}, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Title).To(Equal("Example"))
+
+			var stored page
+			Expect(lruCache.Get(ctx, "page", &stored)).To(Succeed())
+			Expect(stored).To(Equal(result))
+			Expect(loader.Stats()).To(Equal(cache.LoaderStats{Misses: 1, Loads: 1}))
+		})
+
+		It("should serve cached keys without loading", func() {
+			Expect(lruCache.Set(ctx, "page", page{Title: "Cached"}, 0)).To(Succeed())
+
+			var result page
+			err := loader.GetOrLoad(ctx, "page", &result, time.Minute, func(ctx context.Context) (any, error) {
+				Fail("loader should not run")
+				return nil, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Title).To(Equal("Cached"))
+			Expect(loader.Stats()).To(Equal(cache.LoaderStats{Hits: 1}))
+		})
+
+		It("should not store failed loads", func() {
+			var result page
+			err := loader.GetOrLoad(ctx, "page", &result, time.Minute, func(ctx context.Context) (any, error) {
+				return nil, errors.New("unreachable host")
+			})
+			Expect(err).To(MatchError("unreachable host"))
+			Expect(lruCache.Exists(ctx, "page")).To(BeFalse())
+			Expect(loader.Stats().LoadErrors).To(Equal(int64(1)))
+		})
+
+		It("should store and share loaded values in the format of the cache", func() {
+			lruCache.SetCodec(cache.GobCodec)
+
+			var result struct{ Extra any }
+			err := loader.GetOrLoad(ctx, "extra", &result, time.Minute, func(ctx context.Context) (any, error) {
+				return struct{ Extra any }{Extra: 3}, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Extra).To(Equal(3))
+
+			result.Extra = nil
+			Expect(lruCache.Get(ctx, "extra", &result)).To(Succeed())
+			Expect(result.Extra).To(Equal(3))
+		})
+
+		It("should wait for the holder of the lock given as Locker", func() {
+			locker := cache.NewMemoryLocker()
+			lock, err := locker.Acquire(ctx, "page", time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+
+			loader := cache.NewLoader(lruCache, &cache.LoaderOptions{Locker: locker, LockPollInterval: 10 * time.Millisecond})
+			done := make(chan error, 1)
+			var result page
+			go func() {
+				done <- loader.GetOrLoad(ctx, "page", &result, time.Minute, func(ctx context.Context) (any, error) {
+					return page{Title: "Loaded"}, nil
+				})
+			}()
+
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+			Expect(lruCache.Set(ctx, "page", page{Title: "Stored by the holder"}, 0)).To(Succeed())
+			Expect(locker.Release(ctx, lock)).To(Succeed())
+
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(result.Title).To(Equal("Stored by the holder"))
+		})
+
+		It("should coalesce concurrent loads of a key", func() {
+			var calls atomic.Int32
+			release := make(chan struct{})
+
+			var wg sync.WaitGroup
+			results := make([]page, 10)
+			for i := range results {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					err := loader.GetOrLoad(ctx, "page", &results[i], time.Minute, func(ctx context.Context) (any, error) {
+						calls.Add(1)
+						<-release
+						return page{Title: "Example"}, nil
+					})
+					Expect(err).NotTo(HaveOccurred())
+				}()
+			}
+
+			Eventually(calls.Load).Should(Equal(int32(1)))
+			Eventually(func() int64 { return loader.Stats().Misses }).Should(Equal(int64(10)))
+			close(release)
+			wg.Wait()
+
+			Expect(calls.Load()).To(Equal(int32(1)))
+			for _, result := range results {
+				Expect(result.Title).To(Equal("Example"))
+			}
+			stats := loader.Stats()
+			Expect(stats.Loads).To(Equal(int64(1)))
+			Expect(stats.Shared).To(Equal(int64(9)))
+		})
+
+		It("should finish a shared load when the caller that started it gives up", func() {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			load := func(ctx context.Context) (any, error) {
+				close(started)
+				select {
+				case <-release:
+					return page{Title: "Example"}, nil
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+
+			firstCtx, cancelFirst := context.WithCancel(ctx)
+			firstDone := make(chan error, 1)
+			go func() {
+				var result page
+				firstDone <- loader.GetOrLoad(firstCtx, "page", &result, time.Minute, load)
+			}()
+			<-started
+
+			secondDone := make(chan error, 1)
+			var result page
+			go func() {
+				secondDone <- loader.GetOrLoad(ctx, "page", &result, time.Minute, load)
+			}()
+			Eventually(func() int64 { return loader.Stats().Misses }).Should(Equal(int64(2)))
+
+			cancelFirst()
+			Eventually(firstDone).Should(Receive(MatchError(context.Canceled)))
+			close(release)
+
+			Eventually(secondDone).Should(Receive(BeNil()))
+			Expect(result.Title).To(Equal("Example"))
+			Expect(lruCache.Exists(ctx, "page")).To(BeTrue())
+		})
+	})
+
+	Context("with a Redis cache", func() {
+		var (
+			server *miniredis.Miniredis
+			client *redis.Client
+		)
+
+		BeforeEach(func() {
+			server = miniredis.RunT(GinkgoT())
+			client = redis.NewClient(&redis.Options{Addr: server.Addr()})
+			DeferCleanup(client.Close)
+		})
+
+		It("should treat a missing key as a miss", func() {
+			loader := cache.NewLoader(cache.NewRedisCacheWithClient(client), nil)
+
+			var result page
+			err := loader.GetOrLoad(ctx, "page", &result, time.Minute, func(ctx context.Context) (any, error) {
+				return page{Title: "Example"}, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Title).To(Equal("Example"))
+			Expect(server.TTL("page")).To(Equal(time.Minute))
+		})
+
+		It("should let one instance load a key while the others wait", func() {
+			options := &cache.LoaderOptions{DistributedLock: true, LockPollInterval: 10 * time.Millisecond}
+			first := cache.NewLoader(cache.NewRedisCacheWithClient(client), options)
+			second := cache.NewLoader(cache.NewRedisCacheWithClient(client), options)
+
+			var calls atomic.Int32
+			started := make(chan struct{})
+			release := make(chan struct{})
+			load := func(ctx context.Context) (any, error) {
+				if calls.Add(1) == 1 {
+					close(started)
+				}
+				<-release
+				return page{Title: "Example"}, nil
+			}
+
+			done := make(chan error, 2)
+			var firstResult, secondResult page
+			go func() { done <- first.GetOrLoad(ctx, "page", &firstResult, time.Minute, load) }()
+			<-started
+			go func() { done <- second.GetOrLoad(ctx, "page", &secondResult, time.Minute, load) }()
+
+			Consistently(calls.Load, 100*time.Millisecond).Should(Equal(int32(1)))
+			close(release)
+
+			Eventually(done).Should(Receive(BeNil()))
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(calls.Load()).To(Equal(int32(1)))
+			Expect(firstResult.Title).To(Equal("Example"))
+			Expect(secondResult.Title).To(Equal("Example"))
+			Expect(server.Keys()).NotTo(ContainElement(HavePrefix("cache:lock:")))
+		})
+
+		It("should stop waiting for the lock when the context is done", func() {
+			_, err := cache.NewRedisLocker(client).Acquire(ctx, "page", time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			loader := cache.NewLoader(cache.NewRedisCacheWithClient(client), &cache.LoaderOptions{DistributedLock: true})
+
+			waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+
+			var result page
+			err = loader.GetOrLoad(waitCtx, "page", &result, time.Minute, func(ctx context.Context) (any, error) {
+				Fail("loader should not run")
+				return nil, nil
+			})
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+})
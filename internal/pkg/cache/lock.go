@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrLockHeld is returned by Acquire while another owner holds the lock.
+	ErrLockHeld = errors.New("lock held by another owner")
+	// ErrLockLost is returned by Refresh and Release once the lock expired,
+	// whether or not another owner took it since.
+	ErrLockLost = errors.New("lock lost")
+)
+
+// Lock is a lease on a key, held until it expires or is released.
+type Lock struct {
+	Key string
+	// Token grows with every acquisition of Key. Writes tagged with it can be
+	// fenced: their target rejects tokens older than the newest it has seen,
+	// so a holder whose lease expired unnoticed cannot overwrite the work of
+	// the next one.
+	Token int64
+	owner string
+}
+
+// Locker hands out exclusive leases on keys, so that workers sharing a
+// target do not duplicate work on it.
+type Locker interface {
+	// Acquire takes the lock of key for ttl, which must be at least a
+	// millisecond. It does not wait and returns ErrLockHeld if the key is
+	// already locked.
+	Acquire(ctx context.Context, key string, ttl time.Duration) (*Lock, error)
+	// Refresh extends a held lock to ttl from now.
+	Refresh(ctx context.Context, lock *Lock, ttl time.Duration) error
+	Release(ctx context.Context, lock *Lock) error
+}
+
+// acquireScript takes the lock and draws the next fencing token, which
+// outlives the lock so tokens keep growing across holders.
+var acquireScript = redis.NewScript(`
+if not redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 0
+end
+return redis.call('INCR', KEYS[2])
+`)
+
+var refreshScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// RedisLocker keeps locks on a Redis server shared by every worker.
+type RedisLocker struct {
+	client redis.UniversalClient
+}
+
+func NewRedisLocker(client redis.UniversalClient) *RedisLocker {
+	return &RedisLocker{
+		client: client,
+	}
+}
+
+// lockKey and fenceKey share a hash tag so that scripts can use both on a
+// cluster. The tag is a hash of the key, which may contain braces itself.
+func lockKey(key string) string {
+	return "cache:lock:{" + lockTag(key) + "}"
+}
+
+func fenceKey(key string) string {
+	return "cache:fence:{" + lockTag(key) + "}"
+}
+
+func lockTag(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkLockTTL rejects TTLs Redis would round down to no expiration at all.
+func checkLockTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return fmt.Errorf("invalid lock ttl: %v", ttl)
+	}
+	return nil
+}
+
+func (r *RedisLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	if err := checkLockTTL(ttl); err != nil {
+		return nil, err
+	}
+	owner := uuid.New().String()
+	token, err := acquireScript.Run(ctx, r.client, []string{lockKey(key), fenceKey(key)}, owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return nil, unavailableError("acquire", key, err)
+	}
+	if token == 0 {
+		return nil, ErrLockHeld
+	}
+
+	return &Lock{Key: key, Token: token, owner: owner}, nil
+}
+
+func (r *RedisLocker) Refresh(ctx context.Context, lock *Lock, ttl time.Duration) error {
+	if err := checkLockTTL(ttl); err != nil {
+		return err
+	}
+	return r.run(ctx, refreshScript, "refresh", lock, ttl.Milliseconds())
+}
+
+func (r *RedisLocker) Release(ctx context.Context, lock *Lock) error {
+	return r.run(ctx, releaseScript, "release", lock)
+}
+
+func (r *RedisLocker) run(ctx context.Context, script *redis.Script, op string, lock *Lock, args ...any) error {
+	held, err := script.Run(ctx, r.client, []string{lockKey(lock.Key)}, append([]any{lock.owner}, args...)...).Int64()
+	if err != nil {
+		return unavailableError(op, lock.Key, err)
+	}
+	if held == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// MemoryLocker locks keys within a single process, in tests or where there
+// is only one worker.
+type MemoryLocker struct {
+	mutex  sync.Mutex
+	leases map[string]memoryLease
+	tokens map[string]int64
+}
+
+type memoryLease struct {
+	owner      string
+	expiration time.Time
+}
+
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{
+		leases: make(map[string]memoryLease),
+		tokens: make(map[string]int64),
+	}
+}
+
+func (m *MemoryLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	if err := checkLockTTL(ttl); err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if lease, ok := m.leases[key]; ok && time.Now().Before(lease.expiration) {
+		return nil, ErrLockHeld
+	}
+
+	m.tokens[key]++
+	lock := &Lock{Key: key, Token: m.tokens[key], owner: uuid.New().String()}
+	m.leases[key] = memoryLease{owner: lock.owner, expiration: time.Now().Add(ttl)}
+	return lock, nil
+}
+
+func (m *MemoryLocker) Refresh(ctx context.Context, lock *Lock, ttl time.Duration) error {
+	if err := checkLockTTL(ttl); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.holds(lock) {
+		return ErrLockLost
+	}
+	m.leases[lock.Key] = memoryLease{owner: lock.owner, expiration: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryLocker) Release(ctx context.Context, lock *Lock) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.holds(lock) {
+		return ErrLockLost
+	}
+	delete(m.leases, lock.Key)
+	return nil
+}
+
+// holds reports whether lock is still in force. The caller holds m.mutex.
+func (m *MemoryLocker) holds(lock *Lock) bool {
+	lease, ok := m.leases[lock.Key]
+	return ok && lease.owner == lock.owner && time.Now().Before(lease.expiration)
+}
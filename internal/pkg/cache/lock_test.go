@@ -0,0 +1,147 @@
+package cache_test
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+
+	"mmm-osint/internal/pkg/cache"
+)
+
+var _ = Describe("Lockers", func() {
+	lockers := []struct {
+		name      string
+		newLocker func() (cache.Locker, func(d time.Duration))
+	}{
+		{"MemoryLocker", func() (cache.Locker, func(d time.Duration)) {
+			return cache.NewMemoryLocker(), time.Sleep
+		}},
+		{"RedisLocker", func() (cache.Locker, func(d time.Duration)) {
+			server := miniredis.RunT(GinkgoT())
+			client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+			DeferCleanup(client.Close)
+			return cache.NewRedisLocker(client), server.FastForward
+		}},
+	}
+
+	for _, entry := range lockers {
+		Describe(entry.name, func() {
+			var (
+				locker  cache.Locker
+				advance func(d time.Duration)
+				ctx     context.Context
+			)
+
+			BeforeEach(func() {
+				locker, advance = entry.newLocker()
+				ctx = context.Background()
+			})
+
+			It("should hand a lock to one owner at a time", func() {
+				lock, err := locker.Acquire(ctx, "target", time.Minute)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lock.Key).To(Equal("target"))
+
+				_, err = locker.Acquire(ctx, "target", time.Minute)
+				Expect(err).To(MatchError(cache.ErrLockHeld))
+
+				other, err := locker.Acquire(ctx, "other", time.Minute)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(locker.Release(ctx, other)).To(Succeed())
+
+				Expect(locker.Release(ctx, lock)).To(Succeed())
+				_, err = locker.Acquire(ctx, "target", time.Minute)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("should hand out growing fencing tokens", func() {
+				first, err := locker.Acquire(ctx, "target", time.Minute)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(locker.Release(ctx, first)).To(Succeed())
+
+				second, err := locker.Acquire(ctx, "target", time.Minute)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(second.Token).To(BeNumerically(">", first.Token))
+			})
+
+			It("should let another owner take an expired lock", func() {
+				first, err := locker.Acquire(ctx, "target", 50*time.Millisecond)
+				Expect(err).NotTo(HaveOccurred())
+				advance(100 * time.Millisecond)
+
+				second, err := locker.Acquire(ctx, "target", time.Minute)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(second.Token).To(BeNumerically(">", first.Token))
+
+				Expect(locker.Refresh(ctx, first, time.Minute)).To(MatchError(cache.ErrLockLost))
+				Expect(locker.Release(ctx, first)).To(MatchError(cache.ErrLockLost))
+				Expect(locker.Refresh(ctx, second, time.Minute)).To(Succeed())
+			})
+
+			It("should keep refreshed locks", func() {
+				lock, err := locker.Acquire(ctx, "target", 100*time.Millisecond)
+				Expect(err).NotTo(HaveOccurred())
+
+				advance(60 * time.Millisecond)
+				Expect(locker.Refresh(ctx, lock, 100*time.Millisecond)).To(Succeed())
+				advance(60 * time.Millisecond)
+
+				_, err = locker.Acquire(ctx, "target", time.Minute)
+				Expect(err).To(MatchError(cache.ErrLockHeld))
+				Expect(locker.Release(ctx, lock)).To(Succeed())
+			})
+
+			It("should reject TTLs under a millisecond", func() {
+				_, err := locker.Acquire(ctx, "target", time.Microsecond)
+				Expect(err).To(MatchError("invalid lock ttl: 1µs"))
+
+				lock, err := locker.Acquire(ctx, "target", time.Minute)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(locker.Refresh(ctx, lock, 0)).To(MatchError("invalid lock ttl: 0s"))
+				Expect(locker.Release(ctx, lock)).To(Succeed())
+			})
+		})
+	}
+
+	Describe("RedisLocker keys", func() {
+		// hashTag is the part of a key Redis Cluster hashes to pick its slot.
+		hashTag := func(key string) string {
+			if start := strings.Index(key, "{"); start >= 0 {
+				if end := strings.Index(key[start+1:], "}"); end > 0 {
+					return key[start+1 : start+1+end]
+				}
+			}
+			return key
+		}
+
+		It("should keep the lock and fence of keys with braces on one slot", func() {
+			server := miniredis.RunT(GinkgoT())
+			client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+			DeferCleanup(client.Close)
+
+			_, err := cache.NewRedisLocker(client).Acquire(context.Background(), "}page{", time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+
+			keys := server.Keys()
+			Expect(keys).To(HaveLen(2))
+			Expect(hashTag(keys[0])).To(Equal(hashTag(keys[1])))
+		})
+	})
+
+	Describe("RedisLocker without a reachable server", func() {
+		It("should report ErrUnavailable", func() {
+			server := miniredis.RunT(GinkgoT())
+			client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+			DeferCleanup(client.Close)
+			server.Close()
+
+			_, err := cache.NewRedisLocker(client).Acquire(context.Background(), "target", time.Minute)
+			Expect(err).To(MatchError(cache.ErrUnavailable))
+		})
+	})
+})
@@ -2,98 +2,398 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"math"
+	"strings"
 	"sync"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
 )
 
+type LRUConfig struct {
+	// Size caps the number of entries. It may be zero when MaxBytes is set.
+	Size int
+	// MaxBytes caps the size of the keys and encoded values held.
+	MaxBytes int64
+	// SweepInterval is how often Sweep runs. Zero disables it.
+	SweepInterval time.Duration
+	OnEvict       EvictionFunc
+}
+
 type cacheItem struct {
 	value      []byte
 	expiration time.Time
+	tags       []string
+}
+
+func (i *cacheItem) expired(now time.Time) bool {
+	return !i.expiration.IsZero() && now.After(i.expiration)
 }
 
+func itemSize(key string, item *cacheItem) int64 {
+	return int64(len(key) + len(item.value))
+}
+
+// removal tells onEvict why the entry it is handed left the cache.
+type removal int
+
+const (
+	removeDeleted removal = iota
+	removeForSpace
+	removeExpired
+)
+
 type LRUCache struct {
+	valueFormat
+	
 	cache *lru.Cache[string, *cacheItem]
 	mutex sync.RWMutex
+	tags  map[string]map[string]struct{}
+	
+	maxBytes  int64
+	bytes     int64
+	onEvicted EvictionFunc
+	cause     removal
+	evicted   []eviction
+	counters  counters
+	
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 func NewLRUCache(size int) (*LRUCache, error) {
-	cache, err := lru.New[string, *cacheItem](size)
+	return NewLRUCacheWithConfig(&LRUConfig{Size: size})
+}
+
+func NewLRUCacheWithConfig(config *LRUConfig) (*LRUCache, error) {
+	l := &LRUCache{
+		valueFormat: newValueFormat(),
+		tags:        make(map[string]map[string]struct{}),
+		maxBytes:    config.MaxBytes,
+		onEvicted:   config.OnEvict,
+		stop:        make(chan struct{}),
+	}
+	
+	size := config.Size
+	if size <= 0 && config.MaxBytes > 0 {
+		size = math.MaxInt32
+	}
+	cache, err := lru.NewWithEvict[string, *cacheItem](size, l.onEvict)
 	if err != nil {
 		return nil, err
 	}
+	l.cache = cache
+	
+	if config.SweepInterval > 0 {
+		go l.sweep(config.SweepInterval)
+	}
+	
+	return l, nil
+}
+
+// onEvict runs with l.mutex held, whenever the lru cache drops an entry.
+func (l *LRUCache) onEvict(key string, item *cacheItem) {
+	l.forget(key, item)
+	
+	var reason EvictionReason
+	switch l.cause {
+	case removeForSpace:
+		reason = EvictedForSpace
+	case removeExpired:
+		reason = EvictedExpired
+	default:
+		return
+	}
 	
-	return &LRUCache{
-		cache: cache,
-	}, nil
+	l.counters.evicted(reason)
+	if l.onEvicted != nil {
+		l.evicted = append(l.evicted, eviction{key: key, reason: reason})
+	}
+}
+
+// forget drops the bookkeeping of an entry. The caller holds l.mutex.
+func (l *LRUCache) forget(key string, item *cacheItem) {
+	l.bytes -= itemSize(key, item)
+	for _, tag := range item.tags {
+		delete(l.tags[tag], key)
+		if len(l.tags[tag]) == 0 {
+			delete(l.tags, tag)
+		}
+	}
+}
+
+// remove drops key for the given cause. The caller holds l.mutex.
+func (l *LRUCache) remove(key string, cause removal) {
+	l.cause = cause
+	l.cache.Remove(key)
+	l.cause = removeDeleted
+}
+
+// lock and unlock guard writes. unlock runs the eviction callback once the
+// cache is usable again.
+func (l *LRUCache) lock() {
+	l.mutex.Lock()
+}
+
+func (l *LRUCache) unlock() {
+	evicted := l.evicted
+	l.evicted = nil
+	l.mutex.Unlock()
+	
+	for _, e := range evicted {
+		l.onEvicted(e.key, e.reason)
+	}
 }
 
 func (l *LRUCache) Set(ctx context.Context, key string, value any, expiration time.Duration) error {
-	data, err := json.Marshal(value)
+	return l.SetWithTags(ctx, key, value, expiration, nil)
+}
+
+func (l *LRUCache) SetWithTags(ctx context.Context, key string, value any, expiration time.Duration, tags []string) error {
+	data, err := l.encode(key, value)
 	if err != nil {
 		return err
 	}
 	
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
+	l.lock()
+	defer l.unlock()
 	
+	l.add(key, data, expiration, tags)
+	return nil
+}
+
+func (l *LRUCache) setEncoded(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	l.lock()
+	defer l.unlock()
+	
+	l.add(key, data, expiration, nil)
+	return nil
+}
+
+// add stores an encoded entry. The caller holds l.mutex.
+func (l *LRUCache) add(key string, data []byte, expiration time.Duration, tags []string) {
 	var exp time.Time
 	if expiration > 0 {
 		exp = time.Now().Add(expiration)
 	}
 	
-	l.cache.Add(key, &cacheItem{
+	// Add does not report replaced entries to onEvict.
+	if old, ok := l.cache.Peek(key); ok {
+		l.forget(key, old)
+	}
+	
+	item := &cacheItem{
 		value:      data,
 		expiration: exp,
-	})
+		tags:       tags,
+	}
+	l.cause = removeForSpace
+	l.cache.Add(key, item)
+	l.cause = removeDeleted
+	l.bytes += itemSize(key, item)
 	
-	return nil
+	for _, tag := range tags {
+		if l.tags[tag] == nil {
+			l.tags[tag] = make(map[string]struct{})
+		}
+		l.tags[tag][key] = struct{}{}
+	}
+	
+	for l.maxBytes > 0 && l.bytes > l.maxBytes {
+		oldest, _, ok := l.cache.GetOldest()
+		if !ok {
+			break
+		}
+		l.remove(oldest, removeForSpace)
+	}
 }
 
-func (l *LRUCache) Get(ctx context.Context, key string, dest any) error {
+// lookup returns the live entry of key, removing it if it expired.
+func (l *LRUCache) lookup(key string) (*cacheItem, error) {
 	l.mutex.RLock()
 	item, ok := l.cache.Get(key)
 	l.mutex.RUnlock()
 	
 	if !ok {
-		return ErrKeyNotFound
+		return nil, ErrKeyNotFound
+	}
+	
+	if item.expired(time.Now()) {
+		l.removeItem(key, item, removeExpired)
+		return nil, ErrExpired
 	}
 	
-	if !item.expiration.IsZero() && time.Now().After(item.expiration) {
-		l.mutex.Lock()
-		l.cache.Remove(key)
-		l.mutex.Unlock()
-		return ErrKeyNotFound
+	return item, nil
+}
+
+// removeItem drops key if it still holds item.
+func (l *LRUCache) removeItem(key string, item *cacheItem, cause removal) {
+	l.lock()
+	defer l.unlock()
+	
+	// The key may have been stored again since it was read.
+	if current, ok := l.cache.Peek(key); ok && current == item {
+		l.remove(key, cause)
+	}
+}
+
+func (l *LRUCache) Get(ctx context.Context, key string, dest any) error {
+	item, err := l.lookup(key)
+	if err == nil {
+		err = l.decode(key, item.value, dest)
+		if errors.Is(err, ErrStale) {
+			l.removeItem(key, item, removeDeleted)
+		}
 	}
 	
-	return json.Unmarshal(item.value, dest)
+	l.counters.read(!errors.Is(err, ErrKeyNotFound))
+	return err
 }
 
 func (l *LRUCache) Delete(ctx context.Context, key string) error {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
+	l.lock()
+	defer l.unlock()
 	
-	l.cache.Remove(key)
+	l.remove(key, removeDeleted)
 	return nil
 }
 
 func (l *LRUCache) Exists(ctx context.Context, key string) (bool, error) {
-	l.mutex.RLock()
-	item, ok := l.cache.Get(key)
-	l.mutex.RUnlock()
+	_, err := l.lookup(key)
+	return err == nil, nil
+}
+
+func (l *LRUCache) GetMany(ctx context.Context, keys []string, dest any) error {
+	values, err := manyDest(dest)
+	if err != nil {
+		return err
+	}
 	
-	if !ok {
-		return false, nil
+	l.lock()
+	defer l.unlock()
+	
+	now := time.Now()
+	for _, key := range keys {
+		item, ok := l.cache.Get(key)
+		if ok && item.expired(now) {
+			l.remove(key, removeExpired)
+			ok = false
+		}
+		if ok {
+			err := l.putMany(values, key, item.value)
+			if errors.Is(err, ErrStale) {
+				l.remove(key, removeDeleted)
+				ok = false
+			} else if err != nil {
+				return err
+			}
+		}
+		l.counters.read(ok)
 	}
 	
-	if !item.expiration.IsZero() && time.Now().After(item.expiration) {
-		l.mutex.Lock()
-		l.cache.Remove(key)
-		l.mutex.Unlock()
-		return false, nil
+	return nil
+}
+
+func (l *LRUCache) SetMany(ctx context.Context, values map[string]any, expiration time.Duration) error {
+	encoded := make(map[string][]byte, len(values))
+	for key, value := range values {
+		data, err := l.encode(key, value)
+		if err != nil {
+			return err
+		}
+		encoded[key] = data
+	}
+	
+	return l.setManyEncoded(ctx, encoded, expiration)
+}
+
+func (l *LRUCache) setManyEncoded(ctx context.Context, encoded map[string][]byte, expiration time.Duration) error {
+	l.lock()
+	defer l.unlock()
+	
+	for key, data := range encoded {
+		l.add(key, data, expiration, nil)
 	}
 	
-	return true, nil
-}
\ No newline at end of file
+	return nil
+}
+
+func (l *LRUCache) DeleteMany(ctx context.Context, keys []string) error {
+	l.lock()
+	defer l.unlock()
+	
+	for _, key := range keys {
+		l.remove(key, removeDeleted)
+	}
+	
+	return nil
+}
+
+func (l *LRUCache) DeletePrefix(ctx context.Context, prefix string) error {
+	l.lock()
+	defer l.unlock()
+	
+	for _, key := range l.cache.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			l.remove(key, removeDeleted)
+		}
+	}
+	
+	return nil
+}
+
+func (l *LRUCache) InvalidateTag(ctx context.Context, tag string) error {
+	l.lock()
+	defer l.unlock()
+	
+	for key := range l.tags[tag] {
+		l.remove(key, removeDeleted)
+	}
+	
+	return nil
+}
+
+// Sweep frees the memory held by expired entries.
+func (l *LRUCache) Sweep() {
+	l.lock()
+	defer l.unlock()
+	
+	now := time.Now()
+	for _, key := range l.cache.Keys() {
+		if item, ok := l.cache.Peek(key); ok && item.expired(now) {
+			l.remove(key, removeExpired)
+		}
+	}
+}
+
+func (l *LRUCache) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.Sweep()
+		}
+	}
+}
+
+func (l *LRUCache) Stats() Stats {
+	stats := l.counters.stats()
+	
+	l.mutex.RLock()
+	stats.Entries = int64(l.cache.Len())
+	stats.Bytes = l.bytes
+	l.mutex.RUnlock()
+	
+	return stats
+}
+
+// Close stops the background sweeper.
+func (l *LRUCache) Close() error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	return nil
+}
@@ -96,7 +96,8 @@ var _ = Describe("LRU Cache", func() {
 
 				// Should not exist after expiration
 				err = lruCache.Get(ctx, key, &result)
-				Expect(err).To(Equal(cache.ErrKeyNotFound))
+				Expect(err).To(Equal(cache.ErrExpired))
+				Expect(err).To(MatchError(cache.ErrKeyNotFound))
 
 				exists, err = lruCache.Exists(ctx, key)
 				Expect(err).NotTo(HaveOccurred())
@@ -215,4 +216,111 @@ var _ = Describe("LRU Cache", func() {
 			Expect(exists).To(BeTrue())
 		})
 	})
-})
\ No newline at end of file
+
+	Describe("Tags", func() {
+		It("should forget the tags of a key that is stored again", func() {
+			Expect(lruCache.SetWithTags(ctx, "key", "value", 0, []string{"old"})).To(Succeed())
+			Expect(lruCache.Set(ctx, "key", "updated", 0)).To(Succeed())
+
+			Expect(lruCache.InvalidateTag(ctx, "old")).To(Succeed())
+			Expect(lruCache.Exists(ctx, "key")).To(BeTrue())
+		})
+
+		It("should not invalidate a key stored again after being evicted", func() {
+			smallCache, err := cache.NewLRUCache(1)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(smallCache.SetWithTags(ctx, "key1", "value1", 0, []string{"tag"})).To(Succeed())
+			Expect(smallCache.Set(ctx, "key2", "value2", 0)).To(Succeed())
+			Expect(smallCache.Set(ctx, "key1", "value1", 0)).To(Succeed())
+
+			Expect(smallCache.InvalidateTag(ctx, "tag")).To(Succeed())
+			Expect(smallCache.Exists(ctx, "key1")).To(BeTrue())
+		})
+	})
+
+	Describe("Size accounting and eviction", func() {
+		var evictions chan string
+
+		newCache := func(config *cache.LRUConfig) *cache.LRUCache {
+			evictions = make(chan string, 10)
+			config.OnEvict = func(key string, reason cache.EvictionReason) {
+				evictions <- key + ":" + reason.String()
+			}
+			c, err := cache.NewLRUCacheWithConfig(config)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(c.Close)
+			return c
+		}
+
+		It("should evict the least recently used entries beyond the byte limit", func() {
+			c := newCache(&cache.LRUConfig{MaxBytes: 110})
+
+			// Each entry takes 1 byte of key, 20 of format header and 12 of JSON.
+			Expect(c.Set(ctx, "a", "0123456789", 0)).To(Succeed())
+			Expect(c.Set(ctx, "b", "0123456789", 0)).To(Succeed())
+			Expect(c.Set(ctx, "c", "0123456789", 0)).To(Succeed())
+			Expect(c.Exists(ctx, "a")).To(BeTrue())
+			Expect(c.Stats().Bytes).To(Equal(int64(99)))
+
+			Expect(c.Set(ctx, "d", "0123456789", 0)).To(Succeed())
+
+			Expect(evictions).To(Receive(Equal("b:space")))
+			Expect(c.Exists(ctx, "b")).To(BeFalse())
+			Expect(c.Exists(ctx, "a")).To(BeTrue())
+
+			stats := c.Stats()
+			Expect(stats.Entries).To(Equal(int64(3)))
+			Expect(stats.Bytes).To(Equal(int64(99)))
+			Expect(stats.Evictions).To(Equal(int64(1)))
+		})
+
+		It("should account for replaced and deleted entries", func() {
+			c := newCache(&cache.LRUConfig{Size: 10})
+
+			Expect(c.Set(ctx, "a", "0123456789", 0)).To(Succeed())
+			Expect(c.Set(ctx, "a", "0", 0)).To(Succeed())
+			Expect(c.Stats().Bytes).To(Equal(int64(24)))
+
+			Expect(c.Delete(ctx, "a")).To(Succeed())
+			Expect(c.Stats().Bytes).To(BeZero())
+			Expect(evictions).NotTo(Receive())
+		})
+
+		It("should report entries evicted by the entry limit", func() {
+			c := newCache(&cache.LRUConfig{Size: 1})
+
+			Expect(c.Set(ctx, "a", 1, 0)).To(Succeed())
+			Expect(c.Set(ctx, "b", 2, 0)).To(Succeed())
+
+			Expect(evictions).To(Receive(Equal("a:space")))
+			Expect(c.Stats().Evictions).To(Equal(int64(1)))
+		})
+
+		It("should sweep expired entries in the background", func() {
+			c := newCache(&cache.LRUConfig{Size: 10, SweepInterval: 10 * time.Millisecond})
+
+			Expect(c.Set(ctx, "short", 1, 20*time.Millisecond)).To(Succeed())
+			Expect(c.Set(ctx, "long", 2, time.Hour)).To(Succeed())
+
+			Eventually(evictions).Should(Receive(Equal("short:expired")))
+			stats := c.Stats()
+			Expect(stats.Entries).To(Equal(int64(1)))
+			Expect(stats.Expirations).To(Equal(int64(1)))
+		})
+
+		It("should let the callback use the cache", func() {
+			var c *cache.LRUCache
+			c, err := cache.NewLRUCacheWithConfig(&cache.LRUConfig{
+				Size: 1,
+				OnEvict: func(key string, reason cache.EvictionReason) {
+					Expect(c.Exists(ctx, key)).To(BeFalse())
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(c.Set(ctx, "a", 1, 0)).To(Succeed())
+			Expect(c.Set(ctx, "b", 2, 0)).To(Succeed())
+		})
+	})
+})
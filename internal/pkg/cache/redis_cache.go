@@ -2,14 +2,40 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"log"
+	"strings"
 	"time"
 
+	"mmm-osint/internal/pkg/redisconn"
+
 	"github.com/redis/go-redis/v9"
 )
 
+// tagScript files a key under a tag set that lives as long as the longest
+// lived of its keys. Keys stored without an expiration, or keeping their
+// previous one, keep the set forever.
+var tagScript = redis.NewScript(`
+local existed = redis.call('EXISTS', KEYS[1])
+local current = redis.call('PTTL', KEYS[1])
+redis.call('SADD', KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl <= 0 then
+	redis.call('PERSIST', KEYS[1])
+elseif existed == 0 or (current >= 0 and current < ttl) then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+end
+return 0
+`)
+
+const scanBatchSize = 1000
+
 type RedisCache struct {
-	client *redis.Client
+	valueFormat
+	
+	client     redis.UniversalClient
+	ownsClient bool
+	counters   counters
 }
 
 func NewRedisCache(addr, password string, db int) *RedisCache {
@@ -20,41 +46,374 @@ func NewRedisCache(addr, password string, db int) *RedisCache {
 	})
 	
 	return &RedisCache{
-		client: client,
+		valueFormat: newValueFormat(),
+		client:      client,
+		ownsClient:  true,
+	}
+}
+
+func NewRedisCacheWithConfig(config *redisconn.Config) (*RedisCache, error) {
+	client, err := redisconn.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	
+	return &RedisCache{
+		valueFormat: newValueFormat(),
+		client:      client,
+		ownsClient:  true,
+	}, nil
+}
+
+// NewRedisCacheWithClient uses a client shared with queues or other caches.
+// Close leaves the client open.
+func NewRedisCacheWithClient(client redis.UniversalClient) *RedisCache {
+	return &RedisCache{
+		valueFormat: newValueFormat(),
+		client:      client,
 	}
 }
 
 func (r *RedisCache) Set(ctx context.Context, key string, value any, expiration time.Duration) error {
-	data, err := json.Marshal(value)
+	data, err := r.encode(key, value)
 	if err != nil {
 		return err
 	}
 	
-	return r.client.Set(ctx, key, data, expiration).Err()
+	return r.setEncoded(ctx, key, data, expiration)
+}
+
+func (r *RedisCache) setEncoded(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	if err := r.client.Set(ctx, key, data, expiration).Err(); err != nil {
+		return unavailableError("set", key, err)
+	}
+	return nil
 }
 
 func (r *RedisCache) Get(ctx context.Context, key string, dest any) error {
-	data, err := r.client.Get(ctx, key).Result()
+	data, err := r.getEncoded(ctx, key)
+	if err == nil {
+		err = r.decode(key, data, dest)
+		if errors.Is(err, ErrStale) {
+			r.dropStale(ctx, key)
+		}
+	}
+	
+	if !errors.Is(err, ErrUnavailable) {
+		r.counters.read(!errors.Is(err, ErrKeyNotFound))
+	}
+	return err
+}
+
+func (r *RedisCache) getEncoded(ctx context.Context, key string) ([]byte, error) {
+	data, err := r.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrKeyNotFound
+	}
 	if err != nil {
-		return err
+		return nil, unavailableError("get", key, err)
+	}
+	return data, nil
+}
+
+// getWithTTL reads key along with how long it has left to live, zero when it
+// does not expire, so that copies of it can expire with it.
+func (r *RedisCache) getWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	data, ttls, err := r.mgetWithTTL(ctx, []string{key})
+	if err != nil {
+		return nil, 0, unavailableError("get", key, err)
+	}
+	if data[0] == nil {
+		return nil, 0, ErrKeyNotFound
+	}
+	return data[0], ttls[0], nil
+}
+
+// mgetWithTTL reads keys like mget, pipelining a PTTL with each GET.
+func (r *RedisCache) mgetWithTTL(ctx context.Context, keys []string) ([][]byte, []time.Duration, error) {
+	gets := make([]*redis.StringCmd, len(keys))
+	pttls := make([]*redis.DurationCmd, len(keys))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			gets[i] = pipe.Get(ctx, key)
+			pttls[i] = pipe.PTTL(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, nil, err
 	}
 	
-	return json.Unmarshal([]byte(data), dest)
+	data := make([][]byte, len(keys))
+	ttls := make([]time.Duration, len(keys))
+	for i := range keys {
+		if value, err := gets[i].Bytes(); err == nil {
+			data[i] = value
+			// PTTL is negative for keys without an expiration.
+			ttls[i] = max(pttls[i].Val(), 0)
+		}
+	}
+	return data, ttls, nil
+}
+
+// dropStale deletes keys written in another format. Failing to do so only
+// leaves them to be read and dropped again.
+func (r *RedisCache) dropStale(ctx context.Context, keys ...string) {
+	if err := r.unlink(ctx, r.client, keys); err != nil {
+		log.Printf("Error dropping stale cache keys %v: %v", keys, err)
+	}
 }
 
 func (r *RedisCache) Delete(ctx context.Context, key string) error {
-	return r.client.Del(ctx, key).Err()
+	if err := r.client.Del(ctx, key).Err(); err != nil {
+		return unavailableError("delete", key, err)
+	}
+	return nil
 }
 
 func (r *RedisCache) Exists(ctx context.Context, key string) (bool, error) {
 	count, err := r.client.Exists(ctx, key).Result()
 	if err != nil {
-		return false, err
+		return false, unavailableError("exists", key, err)
 	}
 	
 	return count > 0, nil
 }
 
+func (r *RedisCache) GetMany(ctx context.Context, keys []string, dest any) error {
+	values, err := manyDest(dest)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	
+	data, err := r.mget(ctx, keys)
+	if err != nil {
+		return unavailableError("getmany", "", err)
+	}
+	
+	var stale []string
+	for i, key := range keys {
+		found := data[i] != nil
+		if found {
+			err := r.putMany(values, key, data[i])
+			if errors.Is(err, ErrStale) {
+				stale = append(stale, key)
+				found = false
+			} else if err != nil {
+				return err
+			}
+		}
+		r.counters.read(found)
+	}
+	if len(stale) > 0 {
+		r.dropStale(ctx, stale...)
+	}
+	
+	return nil
+}
+
+// mget reads keys with MGET, or with pipelined GETs on a cluster where the
+// keys may live on different slots. Missing keys are nil.
+func (r *RedisCache) mget(ctx context.Context, keys []string) ([][]byte, error) {
+	data := make([][]byte, len(keys))
+	
+	if !redisconn.IsCluster(r.client) {
+		results, err := r.client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i, result := range results {
+			if value, ok := result.(string); ok {
+				data[i] = []byte(value)
+			}
+		}
+		return data, nil
+	}
+	
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		if value, err := cmd.Bytes(); err == nil {
+			data[i] = value
+		}
+	}
+	return data, nil
+}
+
+func (r *RedisCache) SetMany(ctx context.Context, values map[string]any, expiration time.Duration) error {
+	encoded := make(map[string][]byte, len(values))
+	for key, value := range values {
+		data, err := r.encode(key, value)
+		if err != nil {
+			return err
+		}
+		encoded[key] = data
+	}
+	
+	return r.setManyEncoded(ctx, encoded, expiration)
+}
+
+func (r *RedisCache) setManyEncoded(ctx context.Context, encoded map[string][]byte, expiration time.Duration) error {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, data := range encoded {
+			pipe.Set(ctx, key, data, expiration)
+		}
+		return nil
+	})
+	if err != nil {
+		return unavailableError("setmany", "", err)
+	}
+	return nil
+}
+
+func (r *RedisCache) DeleteMany(ctx context.Context, keys []string) error {
+	if err := r.unlink(ctx, r.client, keys); err != nil {
+		return unavailableError("deletemany", "", err)
+	}
+	return nil
+}
+
+// unlink deletes keys in one command, or one command per key on a cluster
+// where the keys may live on different slots.
+func (r *RedisCache) unlink(ctx context.Context, client redis.Cmdable, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if !redisconn.IsCluster(r.client) {
+		return client.Unlink(ctx, keys...).Err()
+	}
+	
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Unlink(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
+
+func tagKey(tag string) string {
+	return "cache:tag:" + tag
+}
+
+func (r *RedisCache) SetWithTags(ctx context.Context, key string, value any, expiration time.Duration, tags []string) error {
+	data, err := r.encode(key, value)
+	if err != nil {
+		return err
+	}
+	
+	return r.setWithTagsEncoded(ctx, key, data, expiration, tags)
+}
+
+func (r *RedisCache) setWithTagsEncoded(ctx context.Context, key string, data []byte, expiration time.Duration, tags []string) error {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, data, expiration)
+		for _, tag := range tags {
+			tagScript.Eval(ctx, pipe, []string{tagKey(tag)}, key, expiration.Milliseconds())
+		}
+		return nil
+	})
+	if err != nil {
+		return unavailableError("set", key, err)
+	}
+	return nil
+}
+
+func (r *RedisCache) InvalidateTag(ctx context.Context, tag string) error {
+	_, err := r.invalidateTag(ctx, tag)
+	return err
+}
+
+// invalidateTag deletes the keys filed under tag and returns them.
+func (r *RedisCache) invalidateTag(ctx context.Context, tag string) ([]string, error) {
+	keys, err := r.client.SMembers(ctx, tagKey(tag)).Result()
+	if err != nil {
+		return nil, unavailableError("invalidatetag", tag, err)
+	}
+	
+	if err := r.unlink(ctx, r.client, append(keys, tagKey(tag))); err != nil {
+		return nil, unavailableError("invalidatetag", tag, err)
+	}
+	return keys, nil
+}
+
+// DeletePrefix scans for the keys starting with prefix, on every master of a
+// cluster, and deletes them in batches.
+func (r *RedisCache) DeletePrefix(ctx context.Context, prefix string) error {
+	var err error
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return r.deletePrefix(ctx, node, prefix)
+		})
+	} else {
+		err = r.deletePrefix(ctx, r.client, prefix)
+	}
+	if err != nil {
+		return unavailableError("deleteprefix", prefix, err)
+	}
+	return nil
+}
+
+func (r *RedisCache) deletePrefix(ctx context.Context, client redis.Cmdable, prefix string) error {
+	iter := client.Scan(ctx, 0, scanPattern(prefix), scanBatchSize).Iterator()
+	
+	var batch []string
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == scanBatchSize {
+			if err := r.unlink(ctx, client, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	
+	return r.unlink(ctx, client, batch)
+}
+
+// scanPattern matches the keys starting with prefix, escaping the glob
+// characters it contains.
+func scanPattern(prefix string) string {
+	var pattern strings.Builder
+	for _, c := range prefix {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			pattern.WriteRune('\\')
+		}
+		pattern.WriteRune(c)
+	}
+	pattern.WriteRune('*')
+	return pattern.String()
+}
+
+// Locker locks keys on the Redis server of the cache.
+func (r *RedisCache) Locker() *RedisLocker {
+	return NewRedisLocker(r.client)
+}
+
+// Stats counts the hits and misses of this client. Evictions, entries and
+// bytes are up to the Redis server and are not reported.
+func (r *RedisCache) Stats() Stats {
+	return r.counters.stats()
+}
+
 func (r *RedisCache) Close() error {
+	if !r.ownsClient {
+		return nil
+	}
 	return r.client.Close()
 }
\ No newline at end of file
@@ -5,11 +5,13 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redismock/v9"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-	"github.com/go-redis/redismock/v9"
 	"github.com/redis/go-redis/v9"
 
+	"mmm-osint/internal/pkg/cache"
 )
 
 var _ = Describe("Redis Cache", func() {
@@ -243,4 +245,94 @@ func (m *MockRedisCache) Exists(ctx context.Context, key string) (bool, error) {
 		return false, err
 	}
 	return count > 0, nil
-}
\ No newline at end of file
+}
+
+var _ = Describe("Redis Cache with a shared client", func() {
+	var (
+		client     *redis.Client
+		mockClient redismock.ClientMock
+		ctx        context.Context
+	)
+
+	BeforeEach(func() {
+		client, mockClient = redismock.NewClientMock()
+		ctx = context.Background()
+	})
+
+	AfterEach(func() {
+		Expect(mockClient.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should store and load values through the client", func() {
+		redisCache := cache.NewRedisCacheWithClient(client)
+
+		// Values are stored behind the format header: marker, flags, version
+		// and codec content type.
+		stored := "\xca\x00\x00\x10application/json" + `"value"`
+		mockClient.ExpectSet("shared", []byte(stored), time.Minute).SetVal("OK")
+		mockClient.ExpectGet("shared").SetVal(stored)
+
+		Expect(redisCache.Set(ctx, "shared", "value", time.Minute)).To(Succeed())
+
+		var value string
+		Expect(redisCache.Get(ctx, "shared", &value)).To(Succeed())
+		Expect(value).To(Equal("value"))
+	})
+
+	It("should leave the client open on Close", func() {
+		Expect(cache.NewRedisCacheWithClient(client).Close()).To(Succeed())
+
+		mockClient.ExpectPing().SetVal("PONG")
+		Expect(client.Ping(ctx).Err()).To(Succeed())
+	})
+})
+
+var _ = Describe("Redis Cache tags", func() {
+	var (
+		server     *miniredis.Miniredis
+		redisCache *cache.RedisCache
+		ctx        context.Context
+	)
+
+	BeforeEach(func() {
+		server = miniredis.RunT(GinkgoT())
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		DeferCleanup(client.Close)
+
+		redisCache = cache.NewRedisCacheWithClient(client)
+		ctx = context.Background()
+	})
+
+	It("should keep a tag as long as its longest lived key", func() {
+		Expect(redisCache.SetWithTags(ctx, "short", 1, time.Minute, []string{"tag"})).To(Succeed())
+		Expect(server.TTL("cache:tag:tag")).To(Equal(time.Minute))
+
+		Expect(redisCache.SetWithTags(ctx, "long", 2, time.Hour, []string{"tag"})).To(Succeed())
+		Expect(redisCache.SetWithTags(ctx, "shorter", 3, time.Second, []string{"tag"})).To(Succeed())
+		Expect(server.TTL("cache:tag:tag")).To(Equal(time.Hour))
+
+		Expect(redisCache.SetWithTags(ctx, "forever", 4, 0, []string{"tag"})).To(Succeed())
+		Expect(server.TTL("cache:tag:tag")).To(BeZero())
+
+		Expect(redisCache.SetWithTags(ctx, "again", 5, time.Minute, []string{"tag"})).To(Succeed())
+		Expect(server.TTL("cache:tag:tag")).To(BeZero())
+	})
+
+	It("should keep the tags of keys stored without a new expiration", func() {
+		Expect(redisCache.SetWithTags(ctx, "kept", 1, redis.KeepTTL, []string{"kept"})).To(Succeed())
+		Expect(redisCache.SetWithTags(ctx, "negative", 2, -time.Second, []string{"negative"})).To(Succeed())
+
+		Expect(server.SMembers("cache:tag:kept")).To(ConsistOf("kept"))
+		Expect(server.TTL("cache:tag:kept")).To(BeZero())
+		Expect(server.SMembers("cache:tag:negative")).To(ConsistOf("negative"))
+		Expect(server.TTL("cache:tag:negative")).To(BeZero())
+	})
+
+	It("should remove the tag with its keys", func() {
+		Expect(redisCache.SetWithTags(ctx, "key", 1, 0, []string{"tag"})).To(Succeed())
+		Expect(redisCache.InvalidateTag(ctx, "tag")).To(Succeed())
+
+		Expect(server.Exists("key")).To(BeFalse())
+		Expect(server.Exists("cache:tag:tag")).To(BeFalse())
+	})
+})
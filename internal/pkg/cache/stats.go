@@ -0,0 +1,85 @@
+package cache
+
+import "sync/atomic"
+
+// Stats describes the activity of a cache since it was created. Entries and
+// Bytes are only known to backends that hold the data themselves.
+type Stats struct {
+	Hits        int64
+	Misses      int64
+	Evictions   int64
+	Expirations int64
+	Entries     int64
+	Bytes       int64
+}
+
+// HitRate is the share of reads that found their key.
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type EvictionReason int
+
+const (
+	// EvictedForSpace means the entry made room under a size limit.
+	EvictedForSpace EvictionReason = iota
+	// EvictedExpired means the entry was removed after its expiration. The
+	// local backends remove expired entries when they are read, and all at
+	// once on Sweep, which their SweepInterval runs in the background.
+	EvictedExpired
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictedForSpace:
+		return "space"
+	case EvictedExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// EvictionFunc is called after an entry left the cache on its own, never
+// for Delete and friends. It may use the cache.
+type EvictionFunc func(key string, reason EvictionReason)
+
+type eviction struct {
+	key    string
+	reason EvictionReason
+}
+
+type counters struct {
+	hits        atomic.Int64
+	misses      atomic.Int64
+	evictions   atomic.Int64
+	expirations atomic.Int64
+}
+
+func (c *counters) read(found bool) {
+	if found {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+func (c *counters) evicted(reason EvictionReason) {
+	if reason == EvictedExpired {
+		c.expirations.Add(1)
+	} else {
+		c.evictions.Add(1)
+	}
+}
+
+func (c *counters) stats() Stats {
+	return Stats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Evictions:   c.evictions.Load(),
+		Expirations: c.expirations.Load(),
+	}
+}
@@ -0,0 +1,376 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const DefaultInvalidationChannel = "cache:invalidate"
+
+type TieredOptions struct {
+	// L1TTL caps how long an entry stays in the local cache. Invalidations
+	// can be missed while the subscription reconnects, so it also bounds how
+	// stale a local entry can get. It defaults to one minute.
+	L1TTL time.Duration
+	// L2TTL caps how long an entry stays in Redis. Zero keeps the expiration
+	// given to Set.
+	L2TTL time.Duration
+	// Channel is the pub/sub channel used to invalidate local entries of
+	// other instances.
+	Channel string
+}
+
+func (o *TieredOptions) withDefaults() TieredOptions {
+	options := TieredOptions{
+		L1TTL:   time.Minute,
+		Channel: DefaultInvalidationChannel,
+	}
+	if o != nil {
+		if o.L1TTL > 0 {
+			options.L1TTL = o.L1TTL
+		}
+		if o.L2TTL > 0 {
+			options.L2TTL = o.L2TTL
+		}
+		if o.Channel != "" {
+			options.Channel = o.Channel
+		}
+	}
+	return options
+}
+
+// TieredCache reads from a local LRUCache before Redis and writes through to
+// both. Writes and deletes are announced over Redis pub/sub so that other
+// instances drop their local copy. Values are encoded once, in the format of
+// the TieredCache, and stored as is in both tiers.
+type TieredCache struct {
+	valueFormat
+
+	l1      *LRUCache
+	l2      *RedisCache
+	options TieredOptions
+	id      string
+	// counters tracks reads answered by either tier.
+	counters counters
+	pubsub   *redis.PubSub
+	done     chan struct{}
+	once     sync.Once
+	// ownsTiers is set when the factory built the tiers, so Close closes
+	// them too.
+	ownsTiers bool
+}
+
+func NewTieredCache(l1 *LRUCache, l2 *RedisCache, options *TieredOptions) (*TieredCache, error) {
+	t := &TieredCache{
+		valueFormat: newValueFormat(),
+		l1:          l1,
+		l2:          l2,
+		options:     options.withDefaults(),
+		id:          uuid.New().String(),
+		done:        make(chan struct{}),
+	}
+
+	t.pubsub = l2.client.Subscribe(context.Background(), t.options.Channel)
+	if _, err := t.pubsub.Receive(context.Background()); err != nil {
+		t.pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to cache invalidations: %v", err)
+	}
+
+	go t.listen()
+
+	return t, nil
+}
+
+// invalidation tells other instances which local entries to drop. A nil
+// Prefix drops none by prefix, while an empty one drops every entry.
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	Prefix *string  `json:"prefix,omitempty"`
+}
+
+func (t *TieredCache) listen() {
+	defer close(t.done)
+
+	ctx := context.Background()
+	for msg := range t.pubsub.Channel() {
+		var inv invalidation
+		if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+			log.Printf("Error decoding cache invalidation: %v", err)
+			continue
+		}
+		if inv.Origin == t.id {
+			continue
+		}
+
+		t.l1.DeleteMany(ctx, inv.Keys)
+		if inv.Prefix != nil {
+			t.l1.DeletePrefix(ctx, *inv.Prefix)
+		}
+	}
+}
+
+func (t *TieredCache) publish(ctx context.Context, inv invalidation) {
+	inv.Origin = t.id
+	data, err := json.Marshal(inv)
+	if err != nil {
+		log.Printf("Error encoding cache invalidation: %v", err)
+		return
+	}
+
+	if err := t.l2.client.Publish(ctx, t.options.Channel, data).Err(); err != nil {
+		log.Printf("Error publishing cache invalidation: %v", err)
+	}
+}
+
+func (t *TieredCache) invalidate(ctx context.Context, keys ...string) {
+	t.publish(ctx, invalidation{Keys: keys})
+}
+
+func (t *TieredCache) Set(ctx context.Context, key string, value any, expiration time.Duration) error {
+	data, err := t.encode(key, value)
+	if err != nil {
+		return err
+	}
+
+	return t.setEncoded(ctx, key, data, expiration)
+}
+
+func (t *TieredCache) setEncoded(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	if err := t.l2.setEncoded(ctx, key, data, tierTTL(expiration, t.options.L2TTL)); err != nil {
+		return err
+	}
+	if err := t.l1.setEncoded(ctx, key, data, tierTTL(expiration, t.options.L1TTL)); err != nil {
+		return err
+	}
+
+	t.invalidate(ctx, key)
+	return nil
+}
+
+func (t *TieredCache) Get(ctx context.Context, key string, dest any) error {
+	if item, err := t.l1.lookup(key); err == nil {
+		err := t.decode(key, item.value, dest)
+		if !errors.Is(err, ErrStale) {
+			t.counters.read(true)
+			return err
+		}
+		t.l1.removeItem(key, item, removeDeleted)
+	}
+
+	data, ttl, err := t.l2.getWithTTL(ctx, key)
+	if err == nil {
+		err = t.decode(key, data, dest)
+		if errors.Is(err, ErrStale) {
+			t.l2.dropStale(ctx, key)
+		}
+	}
+	if !errors.Is(err, ErrUnavailable) {
+		t.counters.read(!errors.Is(err, ErrKeyNotFound))
+	}
+	if err != nil {
+		return err
+	}
+
+	// The local copy must not outlive the entry in Redis.
+	return t.l1.setEncoded(ctx, key, data, tierTTL(ttl, t.options.L1TTL))
+}
+
+func (t *TieredCache) Delete(ctx context.Context, key string) error {
+	if err := t.l2.Delete(ctx, key); err != nil {
+		return err
+	}
+	t.l1.Delete(ctx, key)
+
+	t.invalidate(ctx, key)
+	return nil
+}
+
+func (t *TieredCache) Exists(ctx context.Context, key string) (bool, error) {
+	exists, err := t.l1.Exists(ctx, key)
+	if err != nil || exists {
+		return exists, err
+	}
+
+	return t.l2.Exists(ctx, key)
+}
+
+func (t *TieredCache) GetMany(ctx context.Context, keys []string, dest any) error {
+	values, err := manyDest(dest)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, key := range keys {
+		item, err := t.l1.lookup(key)
+		if err == nil {
+			err = t.putMany(values, key, item.value)
+			if errors.Is(err, ErrStale) {
+				t.l1.removeItem(key, item, removeDeleted)
+			} else if err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			missing = append(missing, key)
+		}
+	}
+	t.counters.hits.Add(int64(len(keys) - len(missing)))
+	if len(missing) == 0 {
+		return nil
+	}
+
+	data, ttls, err := t.l2.mgetWithTTL(ctx, missing)
+	if err != nil {
+		return unavailableError("getmany", "", err)
+	}
+
+	var stale []string
+	for i, key := range missing {
+		found := data[i] != nil
+		if found {
+			switch err := t.putMany(values, key, data[i]); {
+			case err == nil:
+				if err := t.l1.setEncoded(ctx, key, data[i], tierTTL(ttls[i], t.options.L1TTL)); err != nil {
+					return err
+				}
+			case errors.Is(err, ErrStale):
+				stale = append(stale, key)
+				found = false
+			default:
+				return err
+			}
+		}
+		t.counters.read(found)
+	}
+	if len(stale) > 0 {
+		t.l2.dropStale(ctx, stale...)
+	}
+
+	return nil
+}
+
+func (t *TieredCache) SetMany(ctx context.Context, values map[string]any, expiration time.Duration) error {
+	encoded := make(map[string][]byte, len(values))
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		data, err := t.encode(key, value)
+		if err != nil {
+			return err
+		}
+		encoded[key] = data
+		keys = append(keys, key)
+	}
+
+	if err := t.l2.setManyEncoded(ctx, encoded, tierTTL(expiration, t.options.L2TTL)); err != nil {
+		return err
+	}
+	if err := t.l1.setManyEncoded(ctx, encoded, tierTTL(expiration, t.options.L1TTL)); err != nil {
+		return err
+	}
+
+	t.invalidate(ctx, keys...)
+	return nil
+}
+
+func (t *TieredCache) DeleteMany(ctx context.Context, keys []string) error {
+	if err := t.l2.DeleteMany(ctx, keys); err != nil {
+		return err
+	}
+	t.l1.DeleteMany(ctx, keys)
+
+	t.invalidate(ctx, keys...)
+	return nil
+}
+
+func (t *TieredCache) SetWithTags(ctx context.Context, key string, value any, expiration time.Duration, tags []string) error {
+	data, err := t.encode(key, value)
+	if err != nil {
+		return err
+	}
+
+	if err := t.l2.setWithTagsEncoded(ctx, key, data, tierTTL(expiration, t.options.L2TTL), tags); err != nil {
+		return err
+	}
+	if err := t.l1.setEncoded(ctx, key, data, tierTTL(expiration, t.options.L1TTL)); err != nil {
+		return err
+	}
+
+	t.invalidate(ctx, key)
+	return nil
+}
+
+// InvalidateTag drops the keys of tag from Redis and from the local tier of
+// every instance. Tags are only tracked in Redis.
+func (t *TieredCache) InvalidateTag(ctx context.Context, tag string) error {
+	keys, err := t.l2.invalidateTag(ctx, tag)
+	if err != nil {
+		return err
+	}
+	t.l1.DeleteMany(ctx, keys)
+
+	t.invalidate(ctx, keys...)
+	return nil
+}
+
+func (t *TieredCache) DeletePrefix(ctx context.Context, prefix string) error {
+	if err := t.l2.DeletePrefix(ctx, prefix); err != nil {
+		return err
+	}
+	t.l1.DeletePrefix(ctx, prefix)
+
+	t.publish(ctx, invalidation{Prefix: &prefix})
+	return nil
+}
+
+// Stats counts the reads answered by either tier. Evictions, entries and
+// bytes are those of the local tier.
+func (t *TieredCache) Stats() Stats {
+	stats := t.counters.stats()
+	local := t.l1.Stats()
+	stats.Evictions = local.Evictions
+	stats.Expirations = local.Expirations
+	stats.Entries = local.Entries
+	stats.Bytes = local.Bytes
+	return stats
+}
+
+// Locker locks keys on the Redis server of the second tier.
+func (t *TieredCache) Locker() *RedisLocker {
+	return t.l2.Locker()
+}
+
+// Close stops listening for invalidations. Tiers passed to NewTieredCache are
+// left open for their owners to close.
+func (t *TieredCache) Close() error {
+	var err error
+	t.once.Do(func() {
+		err = t.pubsub.Close()
+		<-t.done
+		if t.ownsTiers {
+			t.l1.Close()
+			if closeErr := t.l2.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// tierTTL is the shorter of the expiration of an entry and the limit of a
+// tier, where zero means no limit.
+func tierTTL(expiration, limit time.Duration) time.Duration {
+	if limit > 0 && (expiration <= 0 || limit < expiration) {
+		return limit
+	}
+	return expiration
+}
@@ -0,0 +1,175 @@
+package cache_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+
+	"mmm-osint/internal/pkg/cache"
+)
+
+var _ = Describe("Tiered Cache", func() {
+	type result struct {
+		URL   string `json:"url"`
+		Title string `json:"title"`
+	}
+
+	var (
+		server *miniredis.Miniredis
+		client *redis.Client
+		ctx    context.Context
+	)
+
+	newTiered := func(options *cache.TieredOptions) (*cache.TieredCache, *cache.LRUCache) {
+		l1, err := cache.NewLRUCache(10)
+		Expect(err).NotTo(HaveOccurred())
+
+		tiered, err := cache.NewTieredCache(l1, cache.NewRedisCacheWithClient(client), options)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(tiered.Close)
+
+		return tiered, l1
+	}
+
+	BeforeEach(func() {
+		server = miniredis.RunT(GinkgoT())
+		client = redis.NewClient(&redis.Options{Addr: server.Addr()})
+		DeferCleanup(client.Close)
+		ctx = context.Background()
+	})
+
+	It("should write through to both tiers", func() {
+		tiered, l1 := newTiered(nil)
+
+		Expect(tiered.Set(ctx, "page", result{URL: "https://example.com", Title: "Example"}, time.Hour)).To(Succeed())
+
+		var local result
+		Expect(l1.Get(ctx, "page", &local)).To(Succeed())
+		Expect(local.Title).To(Equal("Example"))
+		Expect(server.Exists("page")).To(BeTrue())
+		Expect(server.TTL("page")).To(Equal(time.Hour))
+	})
+
+	It("should serve reads from the local tier", func() {
+		tiered, _ := newTiered(nil)
+		Expect(tiered.Set(ctx, "page", result{Title: "Example"}, 0)).To(Succeed())
+
+		server.Del("page")
+
+		var cached result
+		Expect(tiered.Get(ctx, "page", &cached)).To(Succeed())
+		Expect(cached.Title).To(Equal("Example"))
+	})
+
+	It("should fill the local tier from Redis", func() {
+		tiered, l1 := newTiered(nil)
+		Expect(cache.NewRedisCacheWithClient(client).Set(ctx, "page", result{Title: "Shared"}, 0)).To(Succeed())
+
+		var cached result
+		Expect(tiered.Get(ctx, "page", &cached)).To(Succeed())
+		Expect(cached.Title).To(Equal("Shared"))
+
+		Expect(l1.Exists(ctx, "page")).To(BeTrue())
+	})
+
+	It("should expire local copies with the entry in Redis", func() {
+		tiered, _ := newTiered(nil)
+		other := cache.NewRedisCacheWithClient(client)
+		Expect(other.Set(ctx, "page", result{Title: "Short"}, 200*time.Millisecond)).To(Succeed())
+		Expect(other.Set(ctx, "batch", result{Title: "Short"}, 200*time.Millisecond)).To(Succeed())
+
+		var cached result
+		Expect(tiered.Get(ctx, "page", &cached)).To(Succeed())
+		results := map[string]result{}
+		Expect(tiered.GetMany(ctx, []string{"batch"}, &results)).To(Succeed())
+		Expect(results).To(HaveKey("batch"))
+
+		time.Sleep(250 * time.Millisecond)
+		server.FastForward(250 * time.Millisecond)
+
+		Expect(tiered.Get(ctx, "page", &cached)).To(MatchError(cache.ErrKeyNotFound))
+		results = map[string]result{}
+		Expect(tiered.GetMany(ctx, []string{"batch"}, &results)).To(Succeed())
+		Expect(results).To(BeEmpty())
+	})
+
+	It("should report missing keys", func() {
+		tiered, _ := newTiered(nil)
+
+		var cached result
+		Expect(tiered.Get(ctx, "missing", &cached)).NotTo(Succeed())
+		Expect(tiered.Exists(ctx, "missing")).To(BeFalse())
+	})
+
+	It("should cap each tier at its TTL", func() {
+		tiered, l1 := newTiered(&cache.TieredOptions{L1TTL: 50 * time.Millisecond, L2TTL: time.Minute})
+
+		Expect(tiered.Set(ctx, "page", result{Title: "Example"}, time.Hour)).To(Succeed())
+		Expect(server.TTL("page")).To(Equal(time.Minute))
+
+		Eventually(func() (bool, error) {
+			return l1.Exists(ctx, "page")
+		}).Should(BeFalse())
+		Expect(tiered.Exists(ctx, "page")).To(BeTrue())
+	})
+
+	It("should invalidate the local tier of other instances", func() {
+		first, _ := newTiered(nil)
+		second, secondL1 := newTiered(nil)
+
+		Expect(first.Set(ctx, "page", result{Title: "Old"}, 0)).To(Succeed())
+
+		var cached result
+		Expect(second.Get(ctx, "page", &cached)).To(Succeed())
+		Expect(cached.Title).To(Equal("Old"))
+
+		Expect(first.Set(ctx, "page", result{Title: "New"}, 0)).To(Succeed())
+		Eventually(func() (bool, error) {
+			return secondL1.Exists(ctx, "page")
+		}).Should(BeFalse())
+
+		Expect(second.Get(ctx, "page", &cached)).To(Succeed())
+		Expect(cached.Title).To(Equal("New"))
+
+		Expect(first.Delete(ctx, "page")).To(Succeed())
+		Eventually(func() (bool, error) {
+			return second.Exists(ctx, "page")
+		}).Should(BeFalse())
+	})
+
+	It("should keep its own local entry after announcing a write", func() {
+		tiered, l1 := newTiered(nil)
+
+		Expect(tiered.Set(ctx, "page", result{Title: "Example"}, 0)).To(Succeed())
+		Consistently(func() (bool, error) {
+			return l1.Exists(ctx, "page")
+		}, 100*time.Millisecond).Should(BeTrue())
+	})
+
+	It("should invalidate other instances by prefix and tag", func() {
+		first, _ := newTiered(nil)
+		second, secondL1 := newTiered(nil)
+
+		Expect(first.SetWithTags(ctx, "page:example.com:/", result{Title: "Home"}, 0, []string{"domain:example.com"})).To(Succeed())
+		Expect(first.Set(ctx, "page:example.org:/", result{Title: "Other"}, 0)).To(Succeed())
+
+		var results map[string]result
+		Expect(second.GetMany(ctx, []string{"page:example.com:/", "page:example.org:/"}, &results)).To(Succeed())
+		Expect(results).To(HaveLen(2))
+		Expect(secondL1.Exists(ctx, "page:example.com:/")).To(BeTrue())
+
+		Expect(first.InvalidateTag(ctx, "domain:example.com")).To(Succeed())
+		Eventually(func() (bool, error) {
+			return secondL1.Exists(ctx, "page:example.com:/")
+		}).Should(BeFalse())
+
+		Expect(first.DeletePrefix(ctx, "page:example.org:")).To(Succeed())
+		Eventually(func() (bool, error) {
+			return secondL1.Exists(ctx, "page:example.org:/")
+		}).Should(BeFalse())
+	})
+})
@@ -1,7 +1,11 @@
 package env
 
 import (
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func GetOrDefault(key, defaultValue string) string {
@@ -12,6 +16,47 @@ func GetOrDefault(key, defaultValue string) string {
 	return value
 }
 
+func GetIntOrDefault(key string, defaultValue int) int {
+	return parseOrDefault(key, defaultValue, strconv.Atoi)
+}
+
+func GetBoolOrDefault(key string, defaultValue bool) bool {
+	return parseOrDefault(key, defaultValue, strconv.ParseBool)
+}
+
+func GetDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	return parseOrDefault(key, defaultValue, time.ParseDuration)
+}
+
+// parseOrDefault parses the value of key, falling back to defaultValue when
+// it is unset. Malformed values fall back too, but are logged so that a typo
+// does not go unnoticed.
+func parseOrDefault[T any](key string, defaultValue T, parse func(string) (T, error)) T {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := parse(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid value %q of %s, using %v: %v", raw, key, defaultValue, err)
+		return defaultValue
+	}
+	return value
+}
+
+func GetListOrDefault(key string, defaultValue []string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return defaultValue
+	}
+	return values
+}
+
 func GetHostName() string {
 	h, err := os.Hostname()
 	if err != nil {
@@ -1,7 +1,10 @@
 package env_test
 
 import (
+	"bytes"
+	"log"
 	"os"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -94,6 +97,64 @@ var _ = Describe("Env Utils", func() {
 		})
 	})
 
+	Describe("typed getters", func() {
+		const testKey = "TEST_ENV_VAR_FOR_TESTING"
+
+		AfterEach(func() {
+			os.Unsetenv(testKey)
+		})
+
+		It("should parse integers", func() {
+			os.Setenv(testKey, "42")
+			Expect(env.GetIntOrDefault(testKey, 7)).To(Equal(42))
+
+			os.Setenv(testKey, "not a number")
+			Expect(env.GetIntOrDefault(testKey, 7)).To(Equal(7))
+		})
+
+		It("should parse booleans", func() {
+			os.Setenv(testKey, "true")
+			Expect(env.GetBoolOrDefault(testKey, false)).To(BeTrue())
+
+			os.Setenv(testKey, "0")
+			Expect(env.GetBoolOrDefault(testKey, true)).To(BeFalse())
+
+			os.Unsetenv(testKey)
+			Expect(env.GetBoolOrDefault(testKey, true)).To(BeTrue())
+		})
+
+		It("should parse durations", func() {
+			os.Setenv(testKey, "1m30s")
+			Expect(env.GetDurationOrDefault(testKey, time.Second)).To(Equal(90 * time.Second))
+
+			os.Setenv(testKey, "90")
+			Expect(env.GetDurationOrDefault(testKey, time.Second)).To(Equal(time.Second))
+		})
+
+		It("should log malformed values it ignores", func() {
+			var output bytes.Buffer
+			log.SetOutput(&output)
+			DeferCleanup(log.SetOutput, os.Stderr)
+
+			os.Setenv(testKey, "l")
+			Expect(env.GetIntOrDefault(testKey, 0)).To(Equal(0))
+			Expect(output.String()).To(ContainSubstring(`Ignoring invalid value "l" of ` + testKey))
+
+			output.Reset()
+			os.Unsetenv(testKey)
+			Expect(env.GetIntOrDefault(testKey, 0)).To(Equal(0))
+			Expect(output.String()).To(BeEmpty())
+		})
+
+		It("should split comma separated lists", func() {
+			os.Setenv(testKey, "redis-1:6379, redis-2:6379,,")
+			Expect(env.GetListOrDefault(testKey, nil)).To(Equal([]string{"redis-1:6379", "redis-2:6379"}))
+
+			os.Setenv(testKey, " , ")
+			Expect(env.GetListOrDefault(testKey, []string{"localhost:6379"})).To(Equal([]string{"localhost:6379"}))
+		})
+	})
+
 	Describe("GetHostName", func() {
 		Context("when getting hostname", func() {
 			It("should return a non-empty hostname", func() {
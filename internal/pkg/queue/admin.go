@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"mmm-osint/internal/pkg/env"
+
+	"github.com/google/uuid"
+)
+
+type Admin interface {
+	Length(ctx context.Context) (int64, error)
+	Peek(ctx context.Context, n int) ([]Envelope, error)
+	Purge(ctx context.Context) (int64, error)
+	ListConsumers(ctx context.Context) ([]ConsumerInfo, error)
+	InFlight(ctx context.Context) (int64, error)
+	OldestMessageAge(ctx context.Context) (time.Duration, error)
+}
+
+type ConsumerInfo struct {
+	ID        string    `json:"id"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	// ExpiresAt is when the consumer is presumed gone unless it sends another
+	// heartbeat, three of its own heartbeat intervals after LastSeen.
+	ExpiresAt time.Time `json:"expires_at"`
+	InFlight  int64     `json:"in_flight"`
+}
+
+type consumer struct {
+	id        string
+	host      string
+	startedAt time.Time
+	inFlight  atomic.Int64
+}
+
+func newConsumer() *consumer {
+	return &consumer{
+		id:        uuid.New().String(),
+		host:      env.GetHostName(),
+		startedAt: time.Now().UTC(),
+	}
+}
+
+func (c *consumer) info(heartbeatInterval time.Duration) ConsumerInfo {
+	now := time.Now().UTC()
+	return ConsumerInfo{
+		ID:        c.id,
+		Host:      c.host,
+		StartedAt: c.startedAt,
+		LastSeen:  now,
+		ExpiresAt: now.Add(3 * heartbeatInterval),
+		InFlight:  c.inFlight.Load(),
+	}
+}
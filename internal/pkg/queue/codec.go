@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"fmt"
+	"sync"
+
+	"mmm-osint/internal/pkg/serialize"
+)
+
+const (
+	ContentTypeMessagePack = serialize.ContentTypeMessagePack
+
+	gzipContentTypeSuffix = "+gzip"
+)
+
+// Codec serializes message payloads. Producers record the codec's content
+// type in the envelope, so consumers decode with whichever codec the
+// producer used as long as it is registered.
+type Codec = serialize.Codec
+
+var (
+	JSONCodec        = serialize.JSON
+	MessagePackCodec = serialize.MessagePack
+)
+
+type gzipCodec struct {
+	inner Codec
+}
+
+// NewGzipCodec compresses everything the inner codec produces. Use
+// SetCompressionThreshold instead to only compress large payloads.
+func NewGzipCodec(inner Codec) Codec {
+	return gzipCodec{inner: inner}
+}
+
+func (g gzipCodec) ContentType() string {
+	return g.inner.ContentType() + gzipContentTypeSuffix
+}
+
+func (g gzipCodec) Marshal(v any) ([]byte, error) {
+	data, err := g.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return serialize.Gzip(data)
+}
+
+func (g gzipCodec) Unmarshal(data []byte, v any) error {
+	decompressed, err := serialize.Gunzip(data)
+	if err != nil {
+		return fmt.Errorf("failed to decompress payload: %v", err)
+	}
+	return g.inner.Unmarshal(decompressed, v)
+}
+
+var (
+	codecsMutex sync.RWMutex
+	codecs      = map[string]Codec{}
+)
+
+func init() {
+	for _, codec := range []Codec{JSONCodec, MessagePackCodec} {
+		RegisterCodec(codec)
+		RegisterCodec(NewGzipCodec(codec))
+	}
+}
+
+// RegisterCodec makes a codec available to consumers decoding messages
+// of its content type.
+func RegisterCodec(codec Codec) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	codecs[codec.ContentType()] = codec
+}
+
+func codecFor(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSONCodec, nil
+	}
+
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+
+	codec, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported content type: %s", contentType)
+	}
+	return codec, nil
+}
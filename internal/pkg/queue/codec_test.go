@@ -0,0 +1,147 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Codecs", func() {
+	type page struct {
+		URL       string    `json:"url"`
+		HTMLBody  string    `json:"html_body"`
+		FetchedAt time.Time `json:"fetched_at"`
+	}
+
+	// MessagePack restores times in the local zone, so compare instants.
+	expectPage := func(actual, expected page) {
+		Expect(actual.URL).To(Equal(expected.URL))
+		Expect(actual.HTMLBody).To(Equal(expected.HTMLBody))
+		Expect(actual.FetchedAt).To(BeTemporally("==", expected.FetchedAt))
+	}
+
+	sample := page{
+		URL:       "https://example.com",
+		HTMLBody:  "<html>" + strings.Repeat("<p>hello</p>", 100) + "</html>",
+		FetchedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	DescribeTable("should round trip values",
+		func(codec queue.Codec, contentType string) {
+			Expect(codec.ContentType()).To(Equal(contentType))
+
+			data, err := codec.Marshal(sample)
+			Expect(err).NotTo(HaveOccurred())
+
+			var decoded page
+			Expect(codec.Unmarshal(data, &decoded)).To(Succeed())
+			expectPage(decoded, sample)
+		},
+		Entry("JSON", queue.JSONCodec, "application/json"),
+		Entry("MessagePack", queue.MessagePackCodec, "application/msgpack"),
+		Entry("gzip JSON", queue.NewGzipCodec(queue.JSONCodec), "application/json+gzip"),
+		Entry("gzip MessagePack", queue.NewGzipCodec(queue.MessagePackCodec), "application/msgpack+gzip"),
+	)
+
+	It("should use json field names for MessagePack", func() {
+		data, err := queue.MessagePackCodec.Marshal(sample)
+		Expect(err).NotTo(HaveOccurred())
+
+		var fields map[string]any
+		Expect(queue.MessagePackCodec.Unmarshal(data, &fields)).To(Succeed())
+		Expect(fields).To(HaveKey("html_body"))
+	})
+
+	It("should shrink repetitive payloads when gzipped", func() {
+		plain, err := queue.JSONCodec.Marshal(sample)
+		Expect(err).NotTo(HaveOccurred())
+		compressed, err := queue.NewGzipCodec(queue.JSONCodec).Marshal(sample)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(len(compressed)).To(BeNumerically("<", len(plain)/4))
+	})
+
+	Describe("negotiation through the envelope", func() {
+		var (
+			producer *queue.MemoryQueue[page]
+			ctx      context.Context
+			cancel   context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			producer = queue.NewMemoryQueue[page]("pages")
+			ctx, cancel = context.WithCancel(context.Background())
+		})
+
+		AfterEach(func() {
+			cancel()
+			Expect(producer.Close()).To(Succeed())
+		})
+
+		consume := func() (page, queue.Metadata) {
+			type delivery struct {
+				msg      page
+				metadata queue.Metadata
+			}
+			deliveries := make(chan delivery, 1)
+			go func() {
+				_ = producer.Consume(ctx, func(handlerCtx context.Context, msg page) error {
+					metadata, _ := queue.MetadataFromContext(handlerCtx)
+					deliveries <- delivery{msg: msg, metadata: metadata}
+					return nil
+				})
+			}()
+
+			var d delivery
+			Eventually(deliveries).Should(Receive(&d))
+			return d.msg, d.metadata
+		}
+
+		It("should decode messages with the codec named in the envelope", func() {
+			producer.SetCodec(queue.NewGzipCodec(queue.MessagePackCodec))
+			Expect(producer.Publish(ctx, sample, nil)).To(Succeed())
+
+			producer.SetCodec(queue.JSONCodec)
+			msg, metadata := consume()
+			expectPage(msg, sample)
+			Expect(metadata.ContentType).To(Equal("application/msgpack+gzip"))
+		})
+
+		It("should combine codecs with threshold compression", func() {
+			producer.SetCodec(queue.MessagePackCodec)
+			producer.SetCompressionThreshold(64)
+			Expect(producer.Publish(ctx, sample, nil)).To(Succeed())
+
+			msg, metadata := consume()
+			expectPage(msg, sample)
+			Expect(metadata.ContentType).To(Equal(queue.ContentTypeMessagePack))
+		})
+
+		It("should dead letter messages of unknown content types", func() {
+			producer.SetCodec(unregisteredCodec{})
+			producer.SetRetryPolicy(&queue.RetryPolicy{DeadLetter: true})
+			Expect(producer.Publish(ctx, sample, nil)).To(Succeed())
+
+			go func() {
+				_ = producer.Consume(ctx, func(context.Context, page) error { return nil })
+			}()
+
+			Eventually(producer.DeadLetters).Should(HaveLen(1))
+			Expect(producer.DeadLetters()[0].Headers[queue.HeaderLastError]).To(Equal("unsupported content type: application/x-unregistered"))
+		})
+	})
+})
+
+type unregisteredCodec struct{}
+
+func (unregisteredCodec) ContentType() string { return "application/x-unregistered" }
+
+func (unregisteredCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (unregisteredCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
@@ -0,0 +1,390 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mmm-osint/internal/pkg/cache"
+	"mmm-osint/internal/pkg/env"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var errNoMessage = errors.New("no message available")
+
+const (
+	minErrorBackoff = 100 * time.Millisecond
+	maxErrorBackoff = 5 * time.Second
+)
+
+// errorBackoff spaces out the retries of a loop whose calls to Redis keep
+// failing, so that an outage does not turn it into a busy loop.
+type errorBackoff struct {
+	delay time.Duration
+}
+
+func (b *errorBackoff) wait(ctx context.Context) {
+	b.delay = min(max(2*b.delay, minErrorBackoff), maxErrorBackoff)
+
+	timer := time.NewTimer(b.delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+func (b *errorBackoff) reset() {
+	b.delay = 0
+}
+
+// settledError is a failure the handler already dealt with, by replying with
+// it for instance. It is reported to the hooks but not redelivered.
+type settledError struct {
+	err error
+}
+
+func (e *settledError) Error() string {
+	return e.err.Error()
+}
+
+func (e *settledError) Unwrap() error {
+	return e.err
+}
+
+type transport interface {
+	push(ctx context.Context, priority Priority, data []byte) error
+	schedule(ctx context.Context, priority Priority, data []byte, at time.Time) error
+	pop(ctx context.Context, order []Priority) ([]byte, error)
+}
+
+type deadLetterTransport interface {
+	deadLetter(ctx context.Context, data []byte) error
+}
+
+type core[T any] struct {
+	name      string
+	transport transport
+	format    envelopeFormat
+	weights   map[Priority]int
+	hooks     hookList
+	retry     *RetryPolicy
+	dedup     *deduplicator
+	life      *lifecycle
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+func newCore[T any](name string, transport transport) core[T] {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return core[T]{
+		name:      name,
+		transport: transport,
+		format:    newEnvelopeFormat(),
+		life:      newLifecycle(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (c *core[T]) SetCodec(codec Codec) {
+	c.format.codec = codec
+}
+
+func (c *core[T]) SetSchemaVersion(version int) {
+	c.format.schemaVersion = version
+}
+
+func (c *core[T]) SetCompressionThreshold(threshold int) {
+	c.format.compressionThreshold = threshold
+}
+
+func (c *core[T]) RegisterMigration(fromVersion int, migration Migration) {
+	c.format.migrations[fromVersion] = migration
+}
+
+func (c *core[T]) SetPriorityWeights(weights map[Priority]int) {
+	c.weights = weights
+}
+
+func (c *core[T]) AddHooks(hooks Hooks) {
+	c.hooks = append(c.hooks, hooks)
+}
+
+func (c *core[T]) SetRetryPolicy(policy *RetryPolicy) {
+	c.retry = policy
+}
+
+// SetDeduplication skips messages whose idempotency key was handled
+// successfully within the window.
+func (c *core[T]) SetDeduplication(store cache.Cache, window time.Duration) {
+	c.dedup = newDeduplicator(store, window, c.name)
+}
+
+func (c *core[T]) PublishMessage(msg T) error {
+	return c.Publish(c.ctx, msg, nil)
+}
+
+func (c *core[T]) Publish(ctx context.Context, msg T, options *PublishOptions) error {
+	envelope, data, err := c.encode(ctx, msg, options)
+	if err != nil {
+		return err
+	}
+
+	err = c.transport.push(ctx, envelope.Priority, data)
+	c.hooks.publish(ctx, c.name, envelope.Metadata(), err)
+	return err
+}
+
+func (c *core[T]) PublishAt(ctx context.Context, msg T, at time.Time, options *PublishOptions) error {
+	envelope, data, err := c.encode(ctx, msg, options)
+	if err != nil {
+		return err
+	}
+
+	err = c.transport.schedule(ctx, envelope.Priority, data, at)
+	c.hooks.publish(ctx, c.name, envelope.Metadata(), err)
+	return err
+}
+
+func (c *core[T]) PublishAfter(ctx context.Context, msg T, delay time.Duration, options *PublishOptions) error {
+	return c.PublishAt(ctx, msg, time.Now().Add(delay), options)
+}
+
+func (c *core[T]) encode(ctx context.Context, msg T, options *PublishOptions) (*Envelope, []byte, error) {
+	envelope, err := c.format.encode(msg, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal message: %v", err)
+	}
+	injectTrace(ctx, envelope)
+	if key, ok := IdempotencyKeyFromContext(ctx); ok && envelope.IdempotencyKey == "" {
+		envelope.IdempotencyKey = key
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal message: %v", err)
+	}
+	return envelope, data, nil
+}
+
+func (c *core[T]) ConsumeMessages(handler func(T) error) error {
+	err := c.Consume(c.ctx, func(_ context.Context, msg T) error {
+		return handler(msg)
+	})
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrShutdown) {
+		return nil
+	}
+	return err
+}
+
+func (c *core[T]) Consume(ctx context.Context, handler Handler[T]) error {
+	return c.consume(ctx, func(envelope *Envelope) (func(context.Context) error, error) {
+		var msg T
+		if err := c.format.decodePayload(envelope, &msg); err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context) error {
+			return handler(ctx, msg)
+		}, nil
+	})
+}
+
+// decoder decodes the payload of an envelope and returns the call that
+// handles it, so consumers can decode into types other than T.
+type decoder func(envelope *Envelope) (func(ctx context.Context) error, error)
+
+func (c *core[T]) consume(ctx context.Context, decode decoder) error {
+	if !c.life.enter() {
+		return ErrShutdown
+	}
+	defer c.life.exit()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(c.ctx, cancel)()
+
+	// Shutdown only stops fetching, handlers keep ctx.
+	fetchCtx, stopFetching := context.WithCancel(ctx)
+	defer stopFetching()
+	defer context.AfterFunc(c.life.draining, stopFetching)()
+
+	worker := c.startConsumer(ctx)
+
+	var backoff errorBackoff
+	for {
+		if err := c.fetchErr(ctx, fetchCtx); err != nil {
+			return err
+		}
+
+		payload, err := c.transport.pop(fetchCtx, priorityOrder(c.weights))
+		if err != nil {
+			if err == errNoMessage {
+				backoff.reset()
+				continue
+			}
+			if err := c.fetchErr(ctx, fetchCtx); err != nil {
+				return err
+			}
+			log.Printf("Error getting message from queue (%s): %v", c.name, err)
+			backoff.wait(fetchCtx)
+			continue
+		}
+		backoff.reset()
+
+		worker.inFlight.Add(1)
+		c.handle(ctx, payload, decode)
+		worker.inFlight.Add(-1)
+	}
+}
+
+func (c *core[T]) fetchErr(ctx context.Context, fetchCtx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if fetchCtx.Err() != nil {
+		return ErrShutdown
+	}
+	return nil
+}
+
+// startConsumer runs the transport's background work for a consumer until
+// ctx is done.
+func (c *core[T]) startConsumer(ctx context.Context) *consumer {
+	if scheduler, ok := c.transport.(interface{ runScheduler(context.Context) }); ok {
+		go scheduler.runScheduler(ctx)
+	}
+
+	worker := newConsumer()
+	if registry, ok := c.transport.(interface {
+		trackConsumer(context.Context, *consumer)
+	}); ok {
+		go registry.trackConsumer(ctx, worker)
+	}
+
+	return worker
+}
+
+func (c *core[T]) handle(ctx context.Context, payload []byte, decode decoder) {
+	envelope := parseEnvelope(payload)
+	run, err := decode(envelope)
+	if err != nil {
+		log.Printf("Error unmarshaling message: %v", err)
+		c.deadLetter(ctx, envelope, err)
+		return
+	}
+
+	c.process(ctx, c.life.begin(envelope, payload), run)
+}
+
+func (c *core[T]) process(ctx context.Context, d *delivery, run func(context.Context) error) {
+	defer c.life.finish(d)
+	envelope := d.envelope
+
+	metadata := envelope.Metadata()
+	if metadata.Expired() {
+		log.Printf("Skipping expired message %s for queue (%s)", metadata.ID, c.name)
+		return
+	}
+
+	if c.dedup.seen(ctx, metadata.IdempotencyKey, nil) {
+		log.Printf("Skipping duplicate message %s with idempotency key %s for queue (%s)", metadata.ID, metadata.IdempotencyKey, c.name)
+		return
+	}
+
+	handlerCtx, cancel := handlerContext(extractTrace(ctx, metadata), metadata)
+	defer cancel()
+
+	c.hooks.consume(handlerCtx, c.name, metadata)
+
+	start := time.Now()
+	err := run(handlerCtx)
+	if d.abandoned.Load() {
+		log.Printf("Message %s for queue (%s) was requeued during shutdown", metadata.ID, c.name)
+		return
+	}
+	var settled *settledError
+	if errors.As(err, &settled) {
+		c.hooks.handled(handlerCtx, c.name, metadata, time.Since(start), settled.err)
+		return
+	}
+	c.hooks.handled(handlerCtx, c.name, metadata, time.Since(start), err)
+
+	if err != nil {
+		log.Printf("Error processing message for queue (%s - worker : %s): %v", c.name, env.GetHostName(), err)
+		c.redeliver(handlerCtx, envelope, err)
+		return
+	}
+
+	c.dedup.record(ctx, metadata.IdempotencyKey, true)
+}
+
+func (c *core[T]) redeliver(ctx context.Context, envelope *Envelope, cause error) {
+	if c.retry == nil || envelope.Attempt >= c.retry.MaxAttempts {
+		c.deadLetter(ctx, envelope, cause)
+		return
+	}
+
+	metadata := envelope.Metadata()
+	retry := *envelope
+	retry.Attempt++
+	if retry.ID == "" {
+		retry.ID = uuid.New().String()
+	}
+
+	data, err := json.Marshal(&retry)
+	if err != nil {
+		log.Printf("Error marshaling retry of message %s for queue (%s): %v", metadata.ID, c.name, err)
+		return
+	}
+
+	// The consumer may be shutting down, which must not lose the retry.
+	publishCtx := context.WithoutCancel(ctx)
+	if delay := c.retry.delay(envelope.Attempt); delay > 0 {
+		err = c.transport.schedule(publishCtx, retry.Priority, data, time.Now().Add(delay))
+	} else {
+		err = c.transport.push(publishCtx, retry.Priority, data)
+	}
+	if err != nil {
+		log.Printf("Error retrying message %s for queue (%s): %v", metadata.ID, c.name, err)
+		return
+	}
+
+	c.hooks.retry(ctx, c.name, metadata, cause)
+}
+
+func (c *core[T]) deadLetter(ctx context.Context, envelope *Envelope, cause error) {
+	if c.retry == nil || !c.retry.DeadLetter {
+		return
+	}
+
+	transport, ok := c.transport.(deadLetterTransport)
+	if !ok {
+		return
+	}
+
+	dead := *envelope
+	if dead.ID == "" {
+		dead.ID = uuid.New().String()
+	}
+	dead.Headers = make(map[string]string, len(envelope.Headers)+1)
+	for key, value := range envelope.Headers {
+		dead.Headers[key] = value
+	}
+	dead.Headers[HeaderLastError] = cause.Error()
+
+	data, err := json.Marshal(&dead)
+	if err == nil {
+		err = transport.deadLetter(context.WithoutCancel(ctx), data)
+	}
+	if err != nil {
+		log.Printf("Error dead lettering message %s for queue (%s): %v", dead.ID, c.name, err)
+		return
+	}
+
+	c.hooks.deadLetter(ctx, c.name, dead.Metadata(), cause)
+}
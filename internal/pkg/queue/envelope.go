@@ -0,0 +1,179 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"mmm-osint/internal/pkg/env"
+	"mmm-osint/internal/pkg/serialize"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ContentTypeJSON     = serialize.ContentTypeJSON
+	ContentEncodingGzip = "gzip"
+
+	DefaultSchemaVersion = 1
+)
+
+type Envelope struct {
+	ID              string            `json:"id"`
+	CreatedAt       time.Time         `json:"created_at"`
+	Producer        string            `json:"producer"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	SchemaVersion   int               `json:"schema_version"`
+	ContentType     string            `json:"content_type"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+	Attempt         int               `json:"attempt"`
+	Priority        Priority          `json:"priority,omitempty"`
+	Deadline        *time.Time        `json:"deadline,omitempty"`
+	IdempotencyKey  string            `json:"idempotency_key,omitempty"`
+	Payload         json.RawMessage   `json:"payload"`
+}
+
+func (e *Envelope) Metadata() Metadata {
+	metadata := Metadata{
+		ID:             e.ID,
+		Attempt:        e.Attempt,
+		EnqueuedAt:     e.CreatedAt,
+		OriginHost:     e.Producer,
+		Headers:        e.Headers,
+		SchemaVersion:  e.SchemaVersion,
+		ContentType:    e.ContentType,
+		Priority:       e.Priority,
+		IdempotencyKey: e.IdempotencyKey,
+	}
+	if e.Deadline != nil {
+		metadata.Deadline = *e.Deadline
+	}
+	return metadata
+}
+
+// Migration upgrades a payload from the schema version it is registered
+// for to the next one. Payloads are passed in the format of their codec.
+type Migration func(payload []byte) ([]byte, error)
+
+type envelopeFormat struct {
+	codec                Codec
+	schemaVersion        int
+	compressionThreshold int
+	migrations           map[int]Migration
+}
+
+func newEnvelopeFormat() envelopeFormat {
+	return envelopeFormat{
+		codec:         JSONCodec,
+		schemaVersion: DefaultSchemaVersion,
+		migrations:    make(map[int]Migration),
+	}
+}
+
+func (f *envelopeFormat) encode(msg any, options *PublishOptions) (*Envelope, error) {
+	payload, err := f.codec.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := &Envelope{
+		ID:            uuid.New().String(),
+		CreatedAt:     time.Now().UTC(),
+		Producer:      env.GetHostName(),
+		SchemaVersion: f.schemaVersion,
+		ContentType:   f.codec.ContentType(),
+		Attempt:       1,
+	}
+
+	if options != nil {
+		envelope.Priority = options.Priority.normalize()
+		envelope.IdempotencyKey = options.IdempotencyKey
+		if !options.Deadline.IsZero() {
+			deadline := options.Deadline.UTC()
+			envelope.Deadline = &deadline
+		}
+		if len(options.Headers) > 0 {
+			envelope.Headers = make(map[string]string, len(options.Headers))
+			for key, value := range options.Headers {
+				envelope.Headers[key] = value
+			}
+		}
+	}
+
+	if f.compressionThreshold > 0 && len(payload) >= f.compressionThreshold {
+		if payload, err = serialize.Gzip(payload); err != nil {
+			return nil, fmt.Errorf("failed to compress payload: %v", err)
+		}
+		envelope.ContentEncoding = ContentEncodingGzip
+	}
+
+	// Plain JSON is embedded as is so messages stay readable, anything else
+	// is stored as a base64 string.
+	if envelope.ContentType == ContentTypeJSON && envelope.ContentEncoding == "" {
+		envelope.Payload = payload
+	} else if envelope.Payload, err = json.Marshal(payload); err != nil {
+		return nil, err
+	}
+
+	return envelope, nil
+}
+
+// parseEnvelope also accepts bare payloads published before messages were
+// wrapped in envelopes, so queues can be upgraded while they still hold work.
+func parseEnvelope(data []byte) *Envelope {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.ID == "" || envelope.Payload == nil {
+		return &Envelope{
+			SchemaVersion: DefaultSchemaVersion,
+			ContentType:   ContentTypeJSON,
+			Attempt:       1,
+			Payload:       data,
+		}
+	}
+	return &envelope
+}
+
+func (f *envelopeFormat) decodePayload(envelope *Envelope, dest any) error {
+	codec, err := codecFor(envelope.ContentType)
+	if err != nil {
+		return err
+	}
+
+	payload := []byte(envelope.Payload)
+	if codec.ContentType() != ContentTypeJSON || envelope.ContentEncoding != "" {
+		var encoded []byte
+		if err := json.Unmarshal(payload, &encoded); err != nil {
+			return err
+		}
+		payload = encoded
+	}
+
+	switch envelope.ContentEncoding {
+	case "":
+	case ContentEncodingGzip:
+		decompressed, err := serialize.Gunzip(payload)
+		if err != nil {
+			return fmt.Errorf("failed to decompress payload: %v", err)
+		}
+		payload = decompressed
+	default:
+		return fmt.Errorf("unsupported content encoding: %s", envelope.ContentEncoding)
+	}
+
+	if envelope.SchemaVersion > f.schemaVersion {
+		return fmt.Errorf("unsupported schema version %d (latest known: %d)", envelope.SchemaVersion, f.schemaVersion)
+	}
+
+	for version := envelope.SchemaVersion; version < f.schemaVersion; version++ {
+		migration, ok := f.migrations[version]
+		if !ok {
+			continue
+		}
+		migrated, err := migration(payload)
+		if err != nil {
+			return fmt.Errorf("failed to migrate payload from schema version %d: %v", version, err)
+		}
+		payload = migrated
+	}
+
+	return codec.Unmarshal(payload, dest)
+}
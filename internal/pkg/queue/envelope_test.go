@@ -0,0 +1,165 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/env"
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Envelope", func() {
+	Describe("Metadata", func() {
+		It("should expose the envelope fields", func() {
+			deadline := time.Now().Add(time.Minute)
+			envelope := queue.Envelope{
+				ID:            "msg-1",
+				CreatedAt:     time.Now(),
+				Producer:      "worker-1",
+				Headers:       map[string]string{"source": "crawler"},
+				SchemaVersion: 2,
+				Attempt:       3,
+				Deadline:      &deadline,
+			}
+
+			metadata := envelope.Metadata()
+			Expect(metadata.ID).To(Equal("msg-1"))
+			Expect(metadata.EnqueuedAt).To(Equal(envelope.CreatedAt))
+			Expect(metadata.OriginHost).To(Equal("worker-1"))
+			Expect(metadata.Headers).To(HaveKeyWithValue("source", "crawler"))
+			Expect(metadata.SchemaVersion).To(Equal(2))
+			Expect(metadata.Attempt).To(Equal(3))
+			Expect(metadata.Deadline).To(Equal(deadline))
+		})
+	})
+
+	Describe("on the wire", func() {
+		type page struct {
+			URL  string `json:"url"`
+			Body string `json:"body"`
+		}
+
+		var (
+			server *miniredis.Miniredis
+			q      *queue.RedisQueue[page]
+			ctx    context.Context
+			cancel context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			server = miniredis.RunT(GinkgoT())
+
+			var err error
+			q, err = queue.NewRedisQueue[page](server.Addr(), "", "pages")
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx, cancel = context.WithCancel(context.Background())
+		})
+
+		AfterEach(func() {
+			cancel()
+			_ = q.Close()
+		})
+
+		storedEnvelope := func() queue.Envelope {
+			items, err := server.List("pages")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(items).To(HaveLen(1))
+
+			var envelope queue.Envelope
+			Expect(json.Unmarshal([]byte(items[0]), &envelope)).To(Succeed())
+			return envelope
+		}
+
+		consumeOne := func() (page, queue.Metadata) {
+			received := make(chan page, 1)
+			metadatas := make(chan queue.Metadata, 1)
+			go func() {
+				_ = q.Consume(ctx, func(handlerCtx context.Context, msg page) error {
+					metadata, _ := queue.MetadataFromContext(handlerCtx)
+					metadatas <- metadata
+					received <- msg
+					return nil
+				})
+			}()
+
+			var msg page
+			Eventually(received, 3*time.Second).Should(Receive(&msg))
+			return msg, <-metadatas
+		}
+
+		It("should wrap messages in a JSON envelope", func() {
+			Expect(q.Publish(ctx, page{URL: "https://example.com"}, &queue.PublishOptions{
+				Headers: map[string]string{"source": "crawler"},
+			})).To(Succeed())
+
+			envelope := storedEnvelope()
+			Expect(envelope.ID).NotTo(BeEmpty())
+			Expect(envelope.Producer).To(Equal(env.GetHostName()))
+			Expect(envelope.ContentType).To(Equal(queue.ContentTypeJSON))
+			Expect(envelope.ContentEncoding).To(BeEmpty())
+			Expect(envelope.SchemaVersion).To(Equal(queue.DefaultSchemaVersion))
+			Expect(envelope.Headers).To(HaveKeyWithValue("source", "crawler"))
+			Expect(string(envelope.Payload)).To(MatchJSON(`{"url":"https://example.com","body":""}`))
+
+			_, metadata := consumeOne()
+			Expect(metadata.ID).To(Equal(envelope.ID))
+			Expect(metadata.Headers).To(HaveKeyWithValue("source", "crawler"))
+		})
+
+		It("should compress payloads above the threshold", func() {
+			q.SetCompressionThreshold(1024)
+			body := strings.Repeat("<p>boilerplate</p>", 200)
+
+			Expect(q.Publish(ctx, page{URL: "https://example.com", Body: body}, nil)).To(Succeed())
+
+			envelope := storedEnvelope()
+			Expect(envelope.ContentEncoding).To(Equal(queue.ContentEncodingGzip))
+			Expect(len(envelope.Payload)).To(BeNumerically("<", len(body)))
+
+			msg, _ := consumeOne()
+			Expect(msg.Body).To(Equal(body))
+		})
+
+		It("should not compress payloads below the threshold", func() {
+			q.SetCompressionThreshold(1024)
+
+			Expect(q.Publish(ctx, page{URL: "https://example.com"}, nil)).To(Succeed())
+			Expect(storedEnvelope().ContentEncoding).To(BeEmpty())
+		})
+
+		It("should migrate payloads published with an older schema version", func() {
+			Expect(q.Publish(ctx, page{URL: "example.com"}, nil)).To(Succeed())
+
+			q.SetSchemaVersion(2)
+			q.RegisterMigration(1, func(payload []byte) ([]byte, error) {
+				var old page
+				if err := json.Unmarshal(payload, &old); err != nil {
+					return nil, err
+				}
+				old.URL = "https://" + old.URL
+				return json.Marshal(old)
+			})
+
+			msg, metadata := consumeOne()
+			Expect(msg.URL).To(Equal("https://example.com"))
+			Expect(metadata.SchemaVersion).To(Equal(1))
+		})
+
+		It("should skip payloads from a newer schema version", func() {
+			q.SetSchemaVersion(2)
+			Expect(q.Publish(ctx, page{URL: "https://future.example.com"}, nil)).To(Succeed())
+			q.SetSchemaVersion(1)
+			Expect(q.Publish(ctx, page{URL: "https://current.example.com"}, nil)).To(Succeed())
+
+			msg, _ := consumeOne()
+			Expect(msg.URL).To(Equal("https://current.example.com"))
+		})
+	})
+})
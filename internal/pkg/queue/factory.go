@@ -1,14 +1,13 @@
 package queue
 
-import "mmm-osint/internal/pkg/env"
-
-var REDIS_URI = env.GetOrDefault("REDIS_URI", "localhost:6379")
-var REDIS_PASSWORD = env.GetOrDefault("REDIS_PASSWORD", "")
+import (
+	"mmm-osint/internal/pkg/redisconn"
+)
 
 func Create[T any](queueName QueueName) (Queue[T], error) {
-	return NewRedisQueue[T](REDIS_URI, REDIS_PASSWORD, queueName)
+	return NewRedisQueueWithConfig[T](redisconn.ConfigFromEnv(), queueName)
 }
 
 func CreateRequestResponse[T any, R any](queueName QueueName) (RequestResponseQueue[T, R], error) {
-	return NewRedisRequestResponseQueue[T, R](REDIS_URI, REDIS_PASSWORD, queueName)
+	return NewRedisRequestResponseQueueWithConfig[T, R](redisconn.ConfigFromEnv(), queueName)
 }
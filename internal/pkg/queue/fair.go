@@ -0,0 +1,301 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type PartitionFunc[T any] func(msg T) string
+
+type FairOptions struct {
+	// Limiter bounds each partition key. It defaults to one message per key
+	// at a time within this process.
+	Limiter Limiter
+	// Workers is the number of messages handled at once across all keys.
+	Workers int
+	// Buffer is the number of messages fetched ahead of the handlers so
+	// that other keys can run while one is held back.
+	Buffer int
+	// PollInterval is how often keys waiting on a limiter are retried.
+	PollInterval time.Duration
+}
+
+func (o *FairOptions) withDefaults() FairOptions {
+	options := FairOptions{
+		Workers:      1,
+		Buffer:       100,
+		PollInterval: 100 * time.Millisecond,
+	}
+	if o != nil {
+		if o.Limiter != nil {
+			options.Limiter = o.Limiter
+		}
+		if o.Workers > 0 {
+			options.Workers = o.Workers
+		}
+		if o.Buffer > 0 {
+			options.Buffer = o.Buffer
+		}
+		if o.PollInterval > 0 {
+			options.PollInterval = o.PollInterval
+		}
+	}
+	if options.Limiter == nil {
+		options.Limiter = NewLocalLimiter(1, 0)
+	}
+	return options
+}
+
+type fairMessage struct {
+	payload  []byte
+	envelope *Envelope
+	run      func(context.Context) error
+}
+
+// fairBuffer holds fetched messages grouped by partition key, with the keys
+// in the order they are offered to the limiter.
+type fairBuffer struct {
+	mutex    sync.Mutex
+	keys     []string
+	messages map[string][]fairMessage
+	size     int
+	ready    chan struct{}
+	space    chan struct{}
+}
+
+func newFairBuffer() *fairBuffer {
+	return &fairBuffer{
+		messages: make(map[string][]fairMessage),
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+	}
+}
+
+func (b *fairBuffer) add(key string, msg fairMessage) {
+	b.mutex.Lock()
+	if _, ok := b.messages[key]; !ok {
+		b.keys = append(b.keys, key)
+	}
+	b.messages[key] = append(b.messages[key], msg)
+	b.size++
+	b.mutex.Unlock()
+
+	notify(b.ready)
+}
+
+func (b *fairBuffer) len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.size
+}
+
+func (b *fairBuffer) snapshot() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]string(nil), b.keys...)
+}
+
+// take removes the oldest message of key and moves key to the back of the
+// rotation.
+func (b *fairBuffer) take(key string) fairMessage {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	messages := b.messages[key]
+	msg := messages[0]
+	b.size--
+
+	for i, k := range b.keys {
+		if k == key {
+			b.keys = append(b.keys[:i], b.keys[i+1:]...)
+			break
+		}
+	}
+	if len(messages) == 1 {
+		delete(b.messages, key)
+	} else {
+		b.messages[key] = messages[1:]
+		b.keys = append(b.keys, key)
+	}
+
+	notify(b.space)
+	return msg
+}
+
+func (b *fairBuffer) drain() []fairMessage {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var drained []fairMessage
+	for _, key := range b.keys {
+		drained = append(drained, b.messages[key]...)
+	}
+	b.keys = nil
+	b.messages = make(map[string][]fairMessage)
+	b.size = 0
+	return drained
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// ConsumeFair handles messages round-robin across the keys returned by
+// partition, holding back keys that their limiter does not admit yet.
+// Messages still buffered when ctx is done are pushed back to the queue.
+func (c *core[T]) ConsumeFair(ctx context.Context, partition PartitionFunc[T], options *FairOptions, handler Handler[T]) error {
+	opts := options.withDefaults()
+
+	if !c.life.enter() {
+		return ErrShutdown
+	}
+	defer c.life.exit()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(c.ctx, cancel)()
+
+	// Shutdown stops fetching and dispatching, handlers keep ctx.
+	fetchCtx, stopFetching := context.WithCancel(ctx)
+	defer stopFetching()
+	defer context.AfterFunc(c.life.draining, stopFetching)()
+
+	worker := c.startConsumer(ctx)
+	buffer := newFairBuffer()
+
+	var fetcher sync.WaitGroup
+	fetcher.Add(1)
+	go func() {
+		defer fetcher.Done()
+		c.fetchFair(fetchCtx, buffer, opts.Buffer, partition, handler)
+	}()
+
+	var handlers sync.WaitGroup
+	slots := make(chan struct{}, opts.Workers)
+
+	for fetchCtx.Err() == nil {
+		select {
+		case slots <- struct{}{}:
+		case <-fetchCtx.Done():
+			continue
+		}
+
+		key, token, msg, wait := c.nextFair(fetchCtx, buffer, opts.Limiter)
+		if token == "" {
+			<-slots
+			if wait <= 0 || wait > opts.PollInterval {
+				wait = opts.PollInterval
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-fetchCtx.Done():
+			case <-buffer.ready:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+
+		worker.inFlight.Add(1)
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			defer func() { <-slots }()
+			defer worker.inFlight.Add(-1)
+			// A released slot may admit a key that was held back.
+			defer notify(buffer.ready)
+
+			c.process(ctx, c.life.begin(msg.envelope, msg.payload), msg.run)
+
+			if err := opts.Limiter.Release(context.WithoutCancel(ctx), key, token); err != nil {
+				log.Printf("Error releasing limiter slot for key %s on queue (%s): %v", key, c.name, err)
+			}
+		}()
+	}
+
+	fetcher.Wait()
+	c.requeue(buffer.drain())
+	handlers.Wait()
+
+	return c.fetchErr(ctx, fetchCtx)
+}
+
+func (c *core[T]) fetchFair(ctx context.Context, buffer *fairBuffer, capacity int, partition PartitionFunc[T], handler Handler[T]) {
+	var backoff errorBackoff
+	for ctx.Err() == nil {
+		if buffer.len() >= capacity {
+			select {
+			case <-ctx.Done():
+			case <-buffer.space:
+			}
+			continue
+		}
+
+		payload, err := c.transport.pop(ctx, priorityOrder(c.weights))
+		if err != nil {
+			if err == errNoMessage {
+				backoff.reset()
+			} else if ctx.Err() == nil {
+				log.Printf("Error getting message from queue (%s): %v", c.name, err)
+				backoff.wait(ctx)
+			}
+			continue
+		}
+		backoff.reset()
+
+		envelope := parseEnvelope(payload)
+		var msg T
+		if err := c.format.decodePayload(envelope, &msg); err != nil {
+			log.Printf("Error unmarshaling message: %v", err)
+			c.deadLetter(ctx, envelope, err)
+			continue
+		}
+
+		buffer.add(partition(msg), fairMessage{
+			payload:  payload,
+			envelope: envelope,
+			run: func(ctx context.Context) error {
+				return handler(ctx, msg)
+			},
+		})
+	}
+}
+
+// nextFair offers the buffered keys to the limiter in rotation and takes a
+// message of the first key it admits. Otherwise it returns how long until the
+// limiter expects to admit one, if it knows.
+func (c *core[T]) nextFair(ctx context.Context, buffer *fairBuffer, limiter Limiter) (string, string, fairMessage, time.Duration) {
+	var wait time.Duration
+
+	for _, key := range buffer.snapshot() {
+		token, retryAfter, err := limiter.Acquire(ctx, key)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error acquiring limiter slot for key %s on queue (%s): %v", key, c.name, err)
+			}
+			continue
+		}
+		if token != "" {
+			return key, token, buffer.take(key), 0
+		}
+		if retryAfter > 0 && (wait == 0 || retryAfter < wait) {
+			wait = retryAfter
+		}
+	}
+
+	return "", "", fairMessage{}, wait
+}
+
+func (c *core[T]) requeue(messages []fairMessage) {
+	ctx := context.Background()
+	for _, msg := range messages {
+		if err := c.transport.push(ctx, msg.envelope.Priority, msg.payload); err != nil {
+			log.Printf("Error requeueing message %s for queue (%s): %v", msg.envelope.ID, c.name, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package queue_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Fair consumption", func() {
+	var (
+		q      *queue.MemoryQueue[string]
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	domain := func(url string) string {
+		return strings.SplitN(url, "/", 2)[0]
+	}
+
+	BeforeEach(func() {
+		q = queue.NewMemoryQueue[string]("fair")
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		cancel()
+		Expect(q.Close()).To(Succeed())
+	})
+
+	publish := func(urls ...string) {
+		for _, url := range urls {
+			Expect(q.Publish(ctx, url, nil)).To(Succeed())
+		}
+	}
+
+	It("should alternate between keys instead of draining one first", func() {
+		publish("a.com/1", "a.com/2", "a.com/3", "a.com/4", "b.com/1", "c.com/1")
+
+		var mutex sync.Mutex
+		var handled []string
+		go func() {
+			_ = q.ConsumeFair(ctx, domain, nil, func(_ context.Context, url string) error {
+				time.Sleep(10 * time.Millisecond)
+				mutex.Lock()
+				handled = append(handled, url)
+				mutex.Unlock()
+				return nil
+			})
+		}()
+
+		Eventually(func() []string {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return append([]string(nil), handled...)
+		}).Should(HaveLen(6))
+
+		Expect(handled[:4]).To(ContainElements("b.com/1", "c.com/1"))
+		Expect(handled).To(ContainElements("a.com/1", "a.com/2", "a.com/3", "a.com/4"))
+	})
+
+	It("should limit concurrent messages per key", func() {
+		publish("a.com/1", "a.com/2", "a.com/3", "a.com/4", "a.com/5", "a.com/6")
+
+		var active, peak atomic.Int32
+		var done atomic.Int32
+		go func() {
+			options := &queue.FairOptions{Workers: 4, Limiter: queue.NewLocalLimiter(2, 0), PollInterval: 5 * time.Millisecond}
+			_ = q.ConsumeFair(ctx, domain, options, func(context.Context, string) error {
+				current := active.Add(1)
+				for {
+					previous := peak.Load()
+					if current <= previous || peak.CompareAndSwap(previous, current) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				active.Add(-1)
+				done.Add(1)
+				return nil
+			})
+		}()
+
+		Eventually(done.Load).Should(Equal(int32(6)))
+		Expect(peak.Load()).To(Equal(int32(2)))
+	})
+
+	It("should space out messages of a rate limited key", func() {
+		publish("a.com/1", "a.com/2", "a.com/3")
+
+		times := make(chan time.Time, 3)
+		go func() {
+			options := &queue.FairOptions{Workers: 3, Limiter: queue.NewLocalLimiter(0, 50*time.Millisecond)}
+			_ = q.ConsumeFair(ctx, domain, options, func(context.Context, string) error {
+				times <- time.Now()
+				return nil
+			})
+		}()
+
+		var first, second, third time.Time
+		Eventually(times).Should(Receive(&first))
+		Eventually(times).Should(Receive(&second))
+		Eventually(times).Should(Receive(&third))
+		Expect(second.Sub(first)).To(BeNumerically(">=", 45*time.Millisecond))
+		Expect(third.Sub(second)).To(BeNumerically(">=", 45*time.Millisecond))
+	})
+
+	It("should push buffered messages back to the queue when stopped", func() {
+		publish("a.com/1", "a.com/2", "a.com/3")
+
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		consumeCtx, stop := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- q.ConsumeFair(consumeCtx, domain, nil, func(context.Context, string) error {
+				started <- struct{}{}
+				<-release
+				return nil
+			})
+		}()
+
+		Eventually(started).Should(Receive())
+		stop()
+		close(release)
+		Eventually(done).Should(Receive(MatchError(context.Canceled)))
+
+		remaining := make(chan string, 3)
+		go func() {
+			_ = q.Consume(ctx, func(_ context.Context, url string) error {
+				remaining <- url
+				return nil
+			})
+		}()
+
+		var urls []string
+		for i := 0; i < 2; i++ {
+			var url string
+			Eventually(remaining).Should(Receive(&url))
+			urls = append(urls, url)
+		}
+		Expect(urls).To(ConsistOf("a.com/2", "a.com/3"))
+	})
+})
@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// Hooks observe a queue's message lifecycle. They are called synchronously
+// from publishers and consumers, so implementations must be fast and safe
+// for concurrent use.
+type Hooks interface {
+	OnPublish(ctx context.Context, queue string, metadata Metadata, err error)
+	OnConsume(ctx context.Context, queue string, metadata Metadata)
+	OnHandled(ctx context.Context, queue string, metadata Metadata, duration time.Duration, err error)
+	OnRetry(ctx context.Context, queue string, metadata Metadata, err error)
+	OnDeadLetter(ctx context.Context, queue string, metadata Metadata, err error)
+}
+
+// NoopHooks can be embedded by hooks that only care about some events.
+type NoopHooks struct{}
+
+func (NoopHooks) OnPublish(context.Context, string, Metadata, error)                {}
+func (NoopHooks) OnConsume(context.Context, string, Metadata)                       {}
+func (NoopHooks) OnHandled(context.Context, string, Metadata, time.Duration, error) {}
+func (NoopHooks) OnRetry(context.Context, string, Metadata, error)                  {}
+func (NoopHooks) OnDeadLetter(context.Context, string, Metadata, error)             {}
+
+type hookList []Hooks
+
+func (h hookList) publish(ctx context.Context, queue string, metadata Metadata, err error) {
+	for _, hooks := range h {
+		hooks.OnPublish(ctx, queue, metadata, err)
+	}
+}
+
+func (h hookList) consume(ctx context.Context, queue string, metadata Metadata) {
+	for _, hooks := range h {
+		hooks.OnConsume(ctx, queue, metadata)
+	}
+}
+
+func (h hookList) handled(ctx context.Context, queue string, metadata Metadata, duration time.Duration, err error) {
+	for _, hooks := range h {
+		hooks.OnHandled(ctx, queue, metadata, duration, err)
+	}
+}
+
+func (h hookList) retry(ctx context.Context, queue string, metadata Metadata, err error) {
+	for _, hooks := range h {
+		hooks.OnRetry(ctx, queue, metadata, err)
+	}
+}
+
+func (h hookList) deadLetter(ctx context.Context, queue string, metadata Metadata, err error) {
+	for _, hooks := range h {
+		hooks.OnDeadLetter(ctx, queue, metadata, err)
+	}
+}
+
+const HeaderLastError = "last-error"
+
+// RetryPolicy redelivers messages whose handler failed. The delay before
+// attempt n+1 is Backoff doubled n-1 times, capped at MaxBackoff. Messages
+// that exhaust their attempts, or cannot be decoded at all, are moved to the
+// dead letter queue when DeadLetter is set and dropped otherwise.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	DeadLetter  bool
+}
+
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	if p.Backoff <= 0 {
+		return 0
+	}
+
+	delay := p.Backoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,168 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+type recordedEvent struct {
+	kind     string
+	queue    string
+	metadata queue.Metadata
+	err      error
+}
+
+type recordingHooks struct {
+	mutex  sync.Mutex
+	events []recordedEvent
+}
+
+func (h *recordingHooks) record(kind, name string, metadata queue.Metadata, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.events = append(h.events, recordedEvent{kind: kind, queue: name, metadata: metadata, err: err})
+}
+
+func (h *recordingHooks) OnPublish(_ context.Context, name string, metadata queue.Metadata, err error) {
+	h.record("publish", name, metadata, err)
+}
+
+func (h *recordingHooks) OnConsume(_ context.Context, name string, metadata queue.Metadata) {
+	h.record("consume", name, metadata, nil)
+}
+
+func (h *recordingHooks) OnHandled(_ context.Context, name string, metadata queue.Metadata, _ time.Duration, err error) {
+	h.record("handled", name, metadata, err)
+}
+
+func (h *recordingHooks) OnRetry(_ context.Context, name string, metadata queue.Metadata, err error) {
+	h.record("retry", name, metadata, err)
+}
+
+func (h *recordingHooks) OnDeadLetter(_ context.Context, name string, metadata queue.Metadata, err error) {
+	h.record("dead_letter", name, metadata, err)
+}
+
+func (h *recordingHooks) kinds() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	kinds := make([]string, len(h.events))
+	for i, event := range h.events {
+		kinds[i] = event.kind
+	}
+	return kinds
+}
+
+var _ = Describe("Queue hooks", func() {
+	var (
+		q      *queue.MemoryQueue[string]
+		hooks  *recordingHooks
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		q = queue.NewMemoryQueue[string]("hooked")
+		hooks = &recordingHooks{}
+		q.AddHooks(hooks)
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		cancel()
+		Expect(q.Close()).To(Succeed())
+	})
+
+	consume := func(handler queue.Handler[string]) {
+		go func() {
+			_ = q.Consume(ctx, handler)
+		}()
+	}
+
+	It("should report publish, consume and successful handling", func() {
+		Expect(q.Publish(ctx, "hello", nil)).To(Succeed())
+		consume(func(context.Context, string) error { return nil })
+
+		Eventually(hooks.kinds).Should(Equal([]string{"publish", "consume", "handled"}))
+		Expect(hooks.events[0].queue).To(Equal("hooked"))
+		Expect(hooks.events[2].err).NotTo(HaveOccurred())
+		Expect(hooks.events[2].metadata.ID).To(Equal(hooks.events[0].metadata.ID))
+	})
+
+	It("should drop failed messages without a retry policy", func() {
+		Expect(q.Publish(ctx, "hello", nil)).To(Succeed())
+		consume(func(context.Context, string) error { return errors.New("boom") })
+
+		Eventually(hooks.kinds).Should(Equal([]string{"publish", "consume", "handled"}))
+		Consistently(hooks.kinds, 100*time.Millisecond).Should(HaveLen(3))
+		Expect(q.DeadLetters()).To(BeEmpty())
+	})
+
+	It("should retry failed messages and dead letter them once attempts are exhausted", func() {
+		q.SetRetryPolicy(&queue.RetryPolicy{MaxAttempts: 3, Backoff: 10 * time.Millisecond, DeadLetter: true})
+
+		attempts := make(chan int, 3)
+		Expect(q.Publish(ctx, "hello", nil)).To(Succeed())
+		consume(func(handlerCtx context.Context, _ string) error {
+			metadata, _ := queue.MetadataFromContext(handlerCtx)
+			attempts <- metadata.Attempt
+			return errors.New("boom")
+		})
+
+		Eventually(attempts).Should(Receive(Equal(1)))
+		Eventually(attempts).Should(Receive(Equal(2)))
+		Eventually(attempts).Should(Receive(Equal(3)))
+
+		Eventually(q.DeadLetters).Should(HaveLen(1))
+		dead := q.DeadLetters()[0]
+		Expect(dead.Attempt).To(Equal(3))
+		Expect(dead.Headers).To(HaveKeyWithValue(queue.HeaderLastError, "boom"))
+		Expect(hooks.kinds()).To(ContainElements("retry", "dead_letter"))
+	})
+
+	It("should dead letter messages that cannot be decoded", func() {
+		q.SetRetryPolicy(&queue.RetryPolicy{MaxAttempts: 3, DeadLetter: true})
+
+		q.SetSchemaVersion(2)
+		Expect(q.Publish(ctx, "from the future", nil)).To(Succeed())
+		q.SetSchemaVersion(1)
+
+		handled := make(chan string, 1)
+		consume(func(_ context.Context, msg string) error {
+			handled <- msg
+			return nil
+		})
+
+		Eventually(q.DeadLetters).Should(HaveLen(1))
+		Expect(q.DeadLetters()[0].Headers[queue.HeaderLastError]).To(ContainSubstring("unsupported schema version"))
+		Expect(handled).NotTo(Receive())
+	})
+
+	Describe("retry backoff", func() {
+		It("should double the delay up to the maximum", func() {
+			q.SetRetryPolicy(&queue.RetryPolicy{MaxAttempts: 5, Backoff: 50 * time.Millisecond, MaxBackoff: 100 * time.Millisecond})
+
+			times := make(chan time.Time, 5)
+			Expect(q.Publish(ctx, "hello", nil)).To(Succeed())
+			consume(func(context.Context, string) error {
+				times <- time.Now()
+				return errors.New("boom")
+			})
+
+			var first, second, third time.Time
+			Eventually(times).Should(Receive(&first))
+			Eventually(times).Should(Receive(&second))
+			Eventually(times).Should(Receive(&third))
+			Expect(second.Sub(first)).To(BeNumerically(">=", 50*time.Millisecond))
+			Expect(third.Sub(second)).To(BeNumerically(">=", 100*time.Millisecond))
+		})
+	})
+})
@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"mmm-osint/internal/pkg/cache"
+)
+
+type idempotencyKeyKey struct{}
+
+// ContextWithIdempotencyKey sets the idempotency key of messages published
+// with ctx that do not set PublishOptions.IdempotencyKey themselves.
+func ContextWithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+func IdempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKeyKey{}).(string)
+	return key, ok && key != ""
+}
+
+// deduplicator remembers completed idempotency keys for a window. Only
+// completions are recorded, so a message that failed can still be retried
+// and two copies handled at the same moment may both run.
+type deduplicator struct {
+	store  cache.Cache
+	window time.Duration
+	prefix string
+}
+
+func newDeduplicator(store cache.Cache, window time.Duration, queueName string) *deduplicator {
+	return &deduplicator{
+		store:  store,
+		window: window,
+		prefix: "queue:" + queueName + ":idempotency:",
+	}
+}
+
+func (d *deduplicator) seen(ctx context.Context, key string, dest any) bool {
+	if d == nil || key == "" {
+		return false
+	}
+
+	exists, err := d.store.Exists(ctx, d.prefix+key)
+	if err != nil {
+		log.Printf("Error checking idempotency key %s: %v", key, err)
+		return false
+	}
+	if !exists || dest == nil {
+		return exists
+	}
+
+	if err := d.store.Get(ctx, d.prefix+key, dest); err != nil {
+		log.Printf("Error reading result for idempotency key %s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+func (d *deduplicator) record(ctx context.Context, key string, result any) {
+	if d == nil || key == "" {
+		return
+	}
+
+	if err := d.store.Set(ctx, d.prefix+key, result, d.window); err != nil {
+		log.Printf("Error recording idempotency key %s: %v", key, err)
+	}
+}
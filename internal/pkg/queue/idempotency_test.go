@@ -0,0 +1,120 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/cache"
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Idempotent consumers", func() {
+	var (
+		q      *queue.MemoryQueue[string]
+		store  *cache.LRUCache
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		q = queue.NewMemoryQueue[string]("crawl")
+
+		var err error
+		store, err = cache.NewLRUCache(100)
+		Expect(err).NotTo(HaveOccurred())
+		q.SetDeduplication(store, time.Minute)
+
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		cancel()
+		Expect(q.Close()).To(Succeed())
+	})
+
+	consume := func(handler queue.Handler[string]) {
+		go func() {
+			_ = q.Consume(ctx, handler)
+		}()
+	}
+
+	It("should run the handler once per idempotency key", func() {
+		options := &queue.PublishOptions{IdempotencyKey: "investigate:https://example.com"}
+		Expect(q.Publish(ctx, "first", options)).To(Succeed())
+		Expect(q.Publish(ctx, "duplicate", options)).To(Succeed())
+		Expect(q.Publish(ctx, "other", &queue.PublishOptions{IdempotencyKey: "investigate:https://other.example.com"})).To(Succeed())
+		Expect(q.Publish(ctx, "unkeyed", nil)).To(Succeed())
+
+		handled := make(chan string, 4)
+		consume(func(_ context.Context, msg string) error {
+			handled <- msg
+			return nil
+		})
+
+		Eventually(handled).Should(Receive(Equal("first")))
+		Eventually(handled).Should(Receive(Equal("other")))
+		Eventually(handled).Should(Receive(Equal("unkeyed")))
+		Consistently(handled, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("should take the idempotency key from the context", func() {
+		keyed := queue.ContextWithIdempotencyKey(ctx, "from-context")
+		Expect(q.Publish(keyed, "first", nil)).To(Succeed())
+		Expect(q.Publish(keyed, "duplicate", nil)).To(Succeed())
+
+		metadatas := make(chan queue.Metadata, 2)
+		consume(func(handlerCtx context.Context, _ string) error {
+			metadata, _ := queue.MetadataFromContext(handlerCtx)
+			metadatas <- metadata
+			return nil
+		})
+
+		var metadata queue.Metadata
+		Eventually(metadatas).Should(Receive(&metadata))
+		Expect(metadata.IdempotencyKey).To(Equal("from-context"))
+		Consistently(metadatas, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("should let failed messages be retried", func() {
+		q.SetRetryPolicy(&queue.RetryPolicy{MaxAttempts: 2})
+		Expect(q.Publish(ctx, "flaky", &queue.PublishOptions{IdempotencyKey: "flaky"})).To(Succeed())
+
+		attempts := make(chan int, 2)
+		consume(func(handlerCtx context.Context, _ string) error {
+			metadata, _ := queue.MetadataFromContext(handlerCtx)
+			attempts <- metadata.Attempt
+			if metadata.Attempt == 1 {
+				return errors.New("temporary failure")
+			}
+			return nil
+		})
+
+		Eventually(attempts).Should(Receive(Equal(1)))
+		Eventually(attempts).Should(Receive(Equal(2)))
+		Eventually(func() (bool, error) {
+			return store.Exists(ctx, "queue:crawl:idempotency:flaky")
+		}).Should(BeTrue())
+	})
+
+	It("should handle keys again once the window has passed", func() {
+		q.SetDeduplication(store, 50*time.Millisecond)
+
+		handled := make(chan string, 2)
+		consume(func(_ context.Context, msg string) error {
+			handled <- msg
+			return nil
+		})
+
+		options := &queue.PublishOptions{IdempotencyKey: "short"}
+		Expect(q.Publish(ctx, "first", options)).To(Succeed())
+		Eventually(handled).Should(Receive(Equal("first")))
+
+		time.Sleep(100 * time.Millisecond)
+		Expect(q.Publish(ctx, "again", options)).To(Succeed())
+		Eventually(handled).Should(Receive(Equal("again")))
+	})
+})
@@ -11,21 +11,56 @@ type Queue[T any] interface {
 	Close() error
 }
 
+type Handler[T any] func(ctx context.Context, msg T) error
+
+type PublishOptions struct {
+	Deadline       time.Time
+	Headers        map[string]string
+	Priority       Priority
+	IdempotencyKey string
+}
+
+type ContextQueue[T any] interface {
+	Publish(ctx context.Context, msg T, options *PublishOptions) error
+	PublishAt(ctx context.Context, msg T, at time.Time, options *PublishOptions) error
+	PublishAfter(ctx context.Context, msg T, delay time.Duration, options *PublishOptions) error
+	Consume(ctx context.Context, handler Handler[T]) error
+	Close() error
+}
+
 type RequestMessage struct {
-	ID      string `json:"id"`
-	ReplyTo string `json:"reply_to"`
-	Data    any    `json:"data"`
+	ID       string     `json:"id"`
+	ReplyTo  string     `json:"reply_to"`
+	Data     any        `json:"data"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+func (m RequestMessage) Expired() bool {
+	return m.Deadline != nil && time.Now().After(*m.Deadline)
 }
 
 type ResponseMessage struct {
-	ID    string `json:"id"`
-	Data  any    `json:"data"`
-	Error string `json:"error,omitempty"`
+	ID          string       `json:"id"`
+	Data        any          `json:"data"`
+	Error       string       `json:"error,omitempty"`
+	RemoteError *RemoteError `json:"remote_error,omitempty"`
+	Partial     bool         `json:"partial,omitempty"`
 }
 
+type Reply[R any] struct {
+	Data  R
+	Final bool
+	Err   error
+}
+
+type StreamHandler[T any, R any] func(ctx context.Context, data T, send func(R) error) (R, error)
+
 type RequestResponseQueue[T any, R any] interface {
 	Queue[RequestMessage]
 	
 	SendAndWait(ctx context.Context, data T, timeout time.Duration) (R, error)
+	SendAndStream(ctx context.Context, data T) (<-chan Reply[R], error)
 	ConsumeWithReply(handler func(T) (R, error)) error
+	ConsumeWithStream(ctx context.Context, handler StreamHandler[T, R]) error
+	Cancel(ctx context.Context, requestID string) error
 }
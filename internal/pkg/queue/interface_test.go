@@ -1,6 +1,8 @@
 package queue_test
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
@@ -20,6 +22,15 @@ var _ = Describe("Queue Interface", func() {
 			Expect(msg.ReplyTo).To(Equal("reply-queue"))
 			Expect(msg.Data).To(Equal("test data"))
 		})
+
+		It("should expire once its deadline has passed", func() {
+			past := time.Now().Add(-time.Second)
+			future := time.Now().Add(time.Minute)
+
+			Expect(queue.RequestMessage{}.Expired()).To(BeFalse())
+			Expect(queue.RequestMessage{Deadline: &past}.Expired()).To(BeTrue())
+			Expect(queue.RequestMessage{Deadline: &future}.Expired()).To(BeFalse())
+		})
 	})
 
 	Describe("ResponseMessage", func() {
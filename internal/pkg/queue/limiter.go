@@ -0,0 +1,167 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Limiter bounds how many messages of a partition key are handled at once
+// and how often one may start.
+type Limiter interface {
+	// Acquire claims a slot for key. When none is available it returns an
+	// empty token and, if known, how long until the rate limit allows the
+	// next message.
+	Acquire(ctx context.Context, key string) (token string, retryAfter time.Duration, err error)
+	Release(ctx context.Context, key string, token string) error
+}
+
+// LocalLimiter limits keys within a single process. A concurrency or
+// interval of zero disables that limit.
+type LocalLimiter struct {
+	concurrency int
+	interval    time.Duration
+	mutex       sync.Mutex
+	active      map[string]map[string]struct{}
+	next        map[string]time.Time
+}
+
+func NewLocalLimiter(concurrency int, interval time.Duration) *LocalLimiter {
+	return &LocalLimiter{
+		concurrency: concurrency,
+		interval:    interval,
+		active:      make(map[string]map[string]struct{}),
+		next:        make(map[string]time.Time),
+	}
+}
+
+func (l *LocalLimiter) Acquire(ctx context.Context, key string) (string, time.Duration, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.concurrency > 0 && len(l.active[key]) >= l.concurrency {
+		return "", 0, nil
+	}
+
+	now := time.Now()
+	if next, ok := l.next[key]; ok {
+		if next.After(now) {
+			return "", next.Sub(now), nil
+		}
+		delete(l.next, key)
+	}
+	if l.interval > 0 {
+		l.next[key] = now.Add(l.interval)
+	}
+
+	token := uuid.New().String()
+	if l.active[key] == nil {
+		l.active[key] = make(map[string]struct{})
+	}
+	l.active[key][token] = struct{}{}
+
+	return token, 0, nil
+}
+
+func (l *LocalLimiter) Release(ctx context.Context, key string, token string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.active[key], token)
+	if len(l.active[key]) == 0 {
+		delete(l.active, key)
+	}
+	return nil
+}
+
+var acquireScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local concurrency = tonumber(ARGV[3])
+local lease = tonumber(ARGV[4])
+
+if concurrency > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+	if redis.call('ZCARD', KEYS[1]) >= concurrency then
+		return -1
+	end
+end
+
+if interval > 0 then
+	local nextAt = tonumber(redis.call('GET', KEYS[2]) or '0')
+	if nextAt > now then
+		return nextAt - now
+	end
+	redis.call('SET', KEYS[2], now + interval, 'PX', interval)
+end
+
+if concurrency > 0 then
+	redis.call('ZADD', KEYS[1], now + lease, ARGV[5])
+	redis.call('PEXPIRE', KEYS[1], lease)
+end
+return 0
+`)
+
+// RedisLimiter shares limits across every consumer of a queue. Slots are
+// leases that expire after the lease TTL, so a consumer that dies while
+// handling a message only holds its slot until then.
+type RedisLimiter struct {
+	client      redis.UniversalClient
+	prefix      string
+	concurrency int
+	interval    time.Duration
+	leaseTTL    time.Duration
+}
+
+func (r *RedisQueue[T]) NewLimiter(concurrency int, interval time.Duration) *RedisLimiter {
+	return &RedisLimiter{
+		client:      r.client,
+		prefix:      r.queueName + "_limit_",
+		concurrency: concurrency,
+		interval:    interval,
+		leaseTTL:    5 * time.Minute,
+	}
+}
+
+func (l *RedisLimiter) SetLeaseTTL(ttl time.Duration) {
+	l.leaseTTL = ttl
+}
+
+func (l *RedisLimiter) leasesKey(key string) string {
+	return l.prefix + "leases_" + key
+}
+
+func (l *RedisLimiter) nextKey(key string) string {
+	return l.prefix + "next_" + key
+}
+
+func (l *RedisLimiter) Acquire(ctx context.Context, key string) (string, time.Duration, error) {
+	token := uuid.New().String()
+
+	wait, err := acquireScript.Run(ctx, l.client,
+		[]string{l.leasesKey(key), l.nextKey(key)},
+		time.Now().UnixMilli(), l.interval.Milliseconds(), l.concurrency, l.leaseTTL.Milliseconds(), token,
+	).Int64()
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch {
+	case wait == 0:
+		return token, 0, nil
+	case wait < 0:
+		return "", 0, nil
+	default:
+		return "", time.Duration(wait) * time.Millisecond, nil
+	}
+}
+
+func (l *RedisLimiter) Release(ctx context.Context, key string, token string) error {
+	if l.concurrency <= 0 {
+		return nil
+	}
+	return l.client.ZRem(ctx, l.leasesKey(key), token).Err()
+}
@@ -0,0 +1,118 @@
+package queue_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Limiters", func() {
+	ctx := context.Background()
+
+	Describe("LocalLimiter", func() {
+		It("should limit concurrent slots per key", func() {
+			limiter := queue.NewLocalLimiter(1, 0)
+
+			token, _, err := limiter.Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).NotTo(BeEmpty())
+
+			blocked, _, err := limiter.Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(blocked).To(BeEmpty())
+
+			other, _, err := limiter.Acquire(ctx, "b.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(other).NotTo(BeEmpty())
+
+			Expect(limiter.Release(ctx, "a.com", token)).To(Succeed())
+			token, _, err = limiter.Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).NotTo(BeEmpty())
+		})
+
+		It("should report when a rate limited key is admitted again", func() {
+			limiter := queue.NewLocalLimiter(0, time.Second)
+
+			token, _, err := limiter.Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).NotTo(BeEmpty())
+
+			token, retryAfter, err := limiter.Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).To(BeEmpty())
+			Expect(retryAfter).To(BeNumerically("~", time.Second, 100*time.Millisecond))
+		})
+	})
+
+	Describe("RedisLimiter", func() {
+		var (
+			server *miniredis.Miniredis
+			first  *queue.RedisQueue[string]
+			second *queue.RedisQueue[string]
+		)
+
+		BeforeEach(func() {
+			server = miniredis.RunT(GinkgoT())
+
+			var err error
+			first, err = queue.NewRedisQueue[string](server.Addr(), "", "crawl")
+			Expect(err).NotTo(HaveOccurred())
+			second, err = queue.NewRedisQueue[string](server.Addr(), "", "crawl")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			_ = first.Close()
+			_ = second.Close()
+		})
+
+		It("should share concurrency limits across consumers", func() {
+			a := first.NewLimiter(1, 0)
+			b := second.NewLimiter(1, 0)
+
+			token, _, err := a.Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).NotTo(BeEmpty())
+
+			blocked, _, err := b.Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(blocked).To(BeEmpty())
+
+			Expect(a.Release(ctx, "a.com", token)).To(Succeed())
+			token, _, err = b.Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).NotTo(BeEmpty())
+		})
+
+		It("should free slots of consumers that never released them", func() {
+			a := first.NewLimiter(1, 0)
+			a.SetLeaseTTL(50 * time.Millisecond)
+
+			token, _, err := a.Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).NotTo(BeEmpty())
+
+			time.Sleep(60 * time.Millisecond)
+			token, _, err = second.NewLimiter(1, 0).Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).NotTo(BeEmpty())
+		})
+
+		It("should share rate limits across consumers", func() {
+			token, _, err := first.NewLimiter(0, time.Second).Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).NotTo(BeEmpty())
+
+			token, retryAfter, err := second.NewLimiter(0, time.Second).Acquire(ctx, "a.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).To(BeEmpty())
+			Expect(retryAfter).To(BeNumerically(">", 500*time.Millisecond))
+		})
+	})
+})
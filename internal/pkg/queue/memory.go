@@ -0,0 +1,162 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+type scheduledMessage struct {
+	at       time.Time
+	priority Priority
+	data     []byte
+}
+
+type scheduleHeap []scheduledMessage
+
+func (h scheduleHeap) Len() int           { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h scheduleHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *scheduleHeap) Push(x any) {
+	*h = append(*h, x.(scheduledMessage))
+}
+
+func (h *scheduleHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+type MemoryQueue[T any] struct {
+	core[T]
+	mutex     sync.Mutex
+	ready     map[Priority][][]byte
+	scheduled scheduleHeap
+	dead      [][]byte
+	notify    chan struct{}
+}
+
+func NewMemoryQueue[T any](queueName QueueName) *MemoryQueue[T] {
+	q := &MemoryQueue[T]{
+		ready:  make(map[Priority][][]byte),
+		notify: make(chan struct{}, 1),
+	}
+	q.core = newCore[T](string(queueName), q)
+
+	return q
+}
+
+func (m *MemoryQueue[T]) push(ctx context.Context, priority Priority, data []byte) error {
+	m.mutex.Lock()
+	m.ready[priority] = append(m.ready[priority], data)
+	m.mutex.Unlock()
+
+	m.signal()
+	return nil
+}
+
+func (m *MemoryQueue[T]) schedule(ctx context.Context, priority Priority, data []byte, at time.Time) error {
+	m.mutex.Lock()
+	heap.Push(&m.scheduled, scheduledMessage{at: at, priority: priority, data: data})
+	m.mutex.Unlock()
+
+	m.signal()
+	return nil
+}
+
+func (m *MemoryQueue[T]) deadLetter(ctx context.Context, data []byte) error {
+	m.mutex.Lock()
+	m.dead = append(m.dead, data)
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *MemoryQueue[T]) DeadLetters() []Envelope {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	envelopes := make([]Envelope, 0, len(m.dead))
+	for _, data := range m.dead {
+		envelopes = append(envelopes, *parseEnvelope(data))
+	}
+	return envelopes
+}
+
+func (m *MemoryQueue[T]) pop(ctx context.Context, order []Priority) ([]byte, error) {
+	for {
+		m.mutex.Lock()
+		m.promoteDue(time.Now())
+
+		if data, ok := m.popReady(order); ok {
+			remaining := m.readyCount()
+			m.mutex.Unlock()
+
+			if remaining > 0 {
+				m.signal()
+			}
+			return data, nil
+		}
+
+		var timer *time.Timer
+		var wake <-chan time.Time
+		if len(m.scheduled) > 0 {
+			timer = time.NewTimer(time.Until(m.scheduled[0].at))
+			wake = timer.C
+		}
+		m.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+		case <-m.notify:
+		case <-wake:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (m *MemoryQueue[T]) promoteDue(now time.Time) {
+	for len(m.scheduled) > 0 && !m.scheduled[0].at.After(now) {
+		item := heap.Pop(&m.scheduled).(scheduledMessage)
+		m.ready[item.priority] = append(m.ready[item.priority], item.data)
+	}
+}
+
+func (m *MemoryQueue[T]) popReady(order []Priority) ([]byte, bool) {
+	for _, priority := range order {
+		if items := m.ready[priority]; len(items) > 0 {
+			m.ready[priority] = items[1:]
+			return items[0], true
+		}
+	}
+	return nil, false
+}
+
+func (m *MemoryQueue[T]) readyCount() int {
+	count := 0
+	for _, items := range m.ready {
+		count += len(items)
+	}
+	return count
+}
+
+func (m *MemoryQueue[T]) signal() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *MemoryQueue[T]) Close() error {
+	m.cancel()
+	return nil
+}
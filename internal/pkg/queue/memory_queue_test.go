@@ -0,0 +1,132 @@
+package queue_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Memory Queue", func() {
+	var (
+		q      *queue.MemoryQueue[string]
+		ctx    context.Context
+		cancel context.CancelFunc
+		msgs   chan string
+	)
+
+	BeforeEach(func() {
+		q = queue.NewMemoryQueue[string]("memory")
+		ctx, cancel = context.WithCancel(context.Background())
+		msgs = make(chan string, 10)
+	})
+
+	AfterEach(func() {
+		cancel()
+		Expect(q.Close()).To(Succeed())
+	})
+
+	startConsumer := func() {
+		go func() {
+			_ = q.Consume(ctx, func(_ context.Context, msg string) error {
+				msgs <- msg
+				return nil
+			})
+		}()
+	}
+
+	It("should deliver messages in FIFO order", func() {
+		Expect(q.Publish(ctx, "first", nil)).To(Succeed())
+		Expect(q.Publish(ctx, "second", nil)).To(Succeed())
+		startConsumer()
+
+		Eventually(msgs).Should(Receive(Equal("first")))
+		Eventually(msgs).Should(Receive(Equal("second")))
+	})
+
+	It("should wake up waiting consumers on publish", func() {
+		startConsumer()
+		time.Sleep(10 * time.Millisecond)
+
+		Expect(q.PublishMessage("hello")).To(Succeed())
+		Eventually(msgs).Should(Receive(Equal("hello")))
+	})
+
+	It("should provide message metadata to handlers", func() {
+		metadatas := make(chan queue.Metadata, 1)
+		go func() {
+			_ = q.Consume(ctx, func(handlerCtx context.Context, msg string) error {
+				metadata, _ := queue.MetadataFromContext(handlerCtx)
+				metadatas <- metadata
+				return nil
+			})
+		}()
+
+		Expect(q.Publish(ctx, "hello", &queue.PublishOptions{Headers: map[string]string{"k": "v"}})).To(Succeed())
+
+		var metadata queue.Metadata
+		Eventually(metadatas).Should(Receive(&metadata))
+		Expect(metadata.ID).NotTo(BeEmpty())
+		Expect(metadata.Attempt).To(Equal(1))
+		Expect(metadata.Headers).To(HaveKeyWithValue("k", "v"))
+	})
+
+	Describe("scheduled delivery", func() {
+		It("should deliver scheduled messages in due order", func() {
+			now := time.Now()
+			Expect(q.PublishAt(ctx, "second", now.Add(80*time.Millisecond), nil)).To(Succeed())
+			Expect(q.PublishAt(ctx, "first", now.Add(40*time.Millisecond), nil)).To(Succeed())
+			Expect(q.Publish(ctx, "immediate", nil)).To(Succeed())
+			startConsumer()
+
+			Eventually(msgs).Should(Receive(Equal("immediate")))
+			Eventually(msgs).Should(Receive(Equal("first")))
+			Eventually(msgs).Should(Receive(Equal("second")))
+			Expect(time.Since(now)).To(BeNumerically(">=", 80*time.Millisecond))
+		})
+
+		It("should not deliver messages before they are due", func() {
+			startConsumer()
+			Expect(q.PublishAfter(ctx, "later", time.Hour, nil)).To(Succeed())
+
+			Consistently(msgs, 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("should keep the publish options of scheduled messages", func() {
+			metadatas := make(chan queue.Metadata, 1)
+			go func() {
+				_ = q.Consume(ctx, func(handlerCtx context.Context, msg string) error {
+					metadata, _ := queue.MetadataFromContext(handlerCtx)
+					metadatas <- metadata
+					return nil
+				})
+			}()
+
+			Expect(q.PublishAfter(ctx, "later", 20*time.Millisecond, &queue.PublishOptions{
+				Headers:        map[string]string{"k": "v"},
+				Priority:       queue.PriorityHigh,
+				IdempotencyKey: "job-1",
+			})).To(Succeed())
+
+			var metadata queue.Metadata
+			Eventually(metadatas).Should(Receive(&metadata))
+			Expect(metadata.Headers).To(HaveKeyWithValue("k", "v"))
+			Expect(metadata.Priority).To(Equal(queue.PriorityHigh))
+			Expect(metadata.IdempotencyKey).To(Equal("job-1"))
+		})
+	})
+
+	It("should stop consuming when closed", func() {
+		done := make(chan error, 1)
+		go func() {
+			done <- q.ConsumeMessages(func(string) error { return nil })
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		Expect(q.Close()).To(Succeed())
+		Eventually(done).Should(Receive(BeNil()))
+	})
+})
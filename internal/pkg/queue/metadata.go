@@ -0,0 +1,55 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+type Metadata struct {
+	ID             string
+	Attempt        int
+	EnqueuedAt     time.Time
+	OriginHost     string
+	Deadline       time.Time
+	Headers        map[string]string
+	SchemaVersion  int
+	ContentType    string
+	Priority       Priority
+	IdempotencyKey string
+}
+
+func (m Metadata) Expired() bool {
+	return !m.Deadline.IsZero() && time.Now().After(m.Deadline)
+}
+
+type metadataKey struct{}
+
+func ContextWithMetadata(ctx context.Context, metadata Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, metadata)
+}
+
+func MetadataFromContext(ctx context.Context) (Metadata, bool) {
+	metadata, ok := ctx.Value(metadataKey{}).(Metadata)
+	return metadata, ok
+}
+
+func handlerContext(ctx context.Context, metadata Metadata) (context.Context, context.CancelFunc) {
+	ctx = ContextWithMetadata(ctx, metadata)
+	if metadata.Deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, metadata.Deadline)
+}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID sets the ID of the request sent with ctx, which is
+// what Cancel takes to stop it later.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDKey{}).(string)
+	return requestID, ok && requestID != ""
+}
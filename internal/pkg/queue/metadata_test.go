@@ -0,0 +1,59 @@
+package queue_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Metadata", func() {
+	It("should round-trip through a context", func() {
+		metadata := queue.Metadata{
+			ID:         "msg-1",
+			Attempt:    2,
+			EnqueuedAt: time.Now(),
+			OriginHost: "worker-1",
+		}
+
+		ctx := queue.ContextWithMetadata(context.Background(), metadata)
+
+		result, ok := queue.MetadataFromContext(ctx)
+		Expect(ok).To(BeTrue())
+		Expect(result).To(Equal(metadata))
+	})
+
+	It("should report missing metadata", func() {
+		_, ok := queue.MetadataFromContext(context.Background())
+		Expect(ok).To(BeFalse())
+	})
+
+	Describe("Expired", func() {
+		It("should not expire without a deadline", func() {
+			Expect(queue.Metadata{}.Expired()).To(BeFalse())
+		})
+
+		It("should expire once the deadline has passed", func() {
+			Expect(queue.Metadata{Deadline: time.Now().Add(-time.Second)}.Expired()).To(BeTrue())
+			Expect(queue.Metadata{Deadline: time.Now().Add(time.Minute)}.Expired()).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Request ID", func() {
+	It("should round-trip through a context", func() {
+		ctx := queue.ContextWithRequestID(context.Background(), "request-1")
+
+		requestID, ok := queue.RequestIDFromContext(ctx)
+		Expect(ok).To(BeTrue())
+		Expect(requestID).To(Equal("request-1"))
+	})
+
+	It("should report a missing request ID", func() {
+		_, ok := queue.RequestIDFromContext(context.Background())
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,191 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+type metricFamily struct {
+	name string
+	kind string
+	help string
+}
+
+var (
+	publishedMetric    = metricFamily{"queue_messages_published_total", "counter", "Messages published, by queue."}
+	publishErrorMetric = metricFamily{"queue_publish_errors_total", "counter", "Messages that failed to publish, by queue."}
+	consumedMetric     = metricFamily{"queue_messages_consumed_total", "counter", "Messages received by consumers, by queue."}
+	handledMetric      = metricFamily{"queue_messages_handled_total", "counter", "Messages processed by handlers, by queue and result."}
+	retriedMetric      = metricFamily{"queue_messages_retried_total", "counter", "Messages scheduled for another attempt, by queue."}
+	deadLetterMetric   = metricFamily{"queue_messages_dead_lettered_total", "counter", "Messages moved to the dead letter queue, by queue."}
+	handlerTimeMetric  = metricFamily{"queue_handler_duration_seconds", "histogram", "Handler latency, by queue."}
+	waitTimeMetric     = metricFamily{"queue_wait_duration_seconds", "histogram", "Time between publishing and consuming a message, by queue."}
+
+	metricFamilies = []metricFamily{
+		publishedMetric, publishErrorMetric, consumedMetric, handledMetric,
+		retriedMetric, deadLetterMetric, handlerTimeMetric, waitTimeMetric,
+	}
+)
+
+type series struct {
+	family string
+	labels string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Metrics is a Hooks implementation that keeps per-queue counters and
+// latency histograms and serves them in the Prometheus text format.
+type Metrics struct {
+	mutex      sync.Mutex
+	buckets    []float64
+	counters   map[series]uint64
+	histograms map[series]*histogram
+}
+
+func NewMetrics() *Metrics {
+	return NewMetricsWithBuckets(DefaultLatencyBuckets)
+}
+
+func NewMetricsWithBuckets(buckets []float64) *Metrics {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	return &Metrics{
+		buckets:    sorted,
+		counters:   make(map[series]uint64),
+		histograms: make(map[series]*histogram),
+	}
+}
+
+func (m *Metrics) OnPublish(_ context.Context, queue string, _ Metadata, err error) {
+	if err != nil {
+		m.inc(publishErrorMetric, queueLabels(queue))
+		return
+	}
+	m.inc(publishedMetric, queueLabels(queue))
+}
+
+func (m *Metrics) OnConsume(_ context.Context, queue string, metadata Metadata) {
+	m.inc(consumedMetric, queueLabels(queue))
+	if !metadata.EnqueuedAt.IsZero() {
+		m.observe(waitTimeMetric, queueLabels(queue), time.Since(metadata.EnqueuedAt))
+	}
+}
+
+func (m *Metrics) OnHandled(_ context.Context, queue string, _ Metadata, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.inc(handledMetric, queueLabels(queue)+`,result="`+result+`"`)
+	m.observe(handlerTimeMetric, queueLabels(queue), duration)
+}
+
+func (m *Metrics) OnRetry(_ context.Context, queue string, _ Metadata, _ error) {
+	m.inc(retriedMetric, queueLabels(queue))
+}
+
+func (m *Metrics) OnDeadLetter(_ context.Context, queue string, _ Metadata, _ error) {
+	m.inc(deadLetterMetric, queueLabels(queue))
+}
+
+func (m *Metrics) inc(family metricFamily, labels string) {
+	m.mutex.Lock()
+	m.counters[series{family.name, labels}]++
+	m.mutex.Unlock()
+}
+
+func (m *Metrics) observe(family metricFamily, labels string, duration time.Duration) {
+	seconds := duration.Seconds()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := series{family.name, labels}
+	h, ok := m.histograms[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.histograms[key] = h
+	}
+
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	out := bufio.NewWriter(w)
+	for _, family := range metricFamilies {
+		fmt.Fprintf(out, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(out, "# TYPE %s %s\n", family.name, family.kind)
+
+		if family.kind == "counter" {
+			for _, key := range sortedSeries(m.counters, family.name) {
+				fmt.Fprintf(out, "%s{%s} %d\n", family.name, key.labels, m.counters[key])
+			}
+			continue
+		}
+
+		for _, key := range sortedSeries(m.histograms, family.name) {
+			h := m.histograms[key]
+			for i, bound := range m.buckets {
+				fmt.Fprintf(out, "%s_bucket{%s,le=\"%s\"} %d\n", family.name, key.labels, formatFloat(bound), h.counts[i])
+			}
+			fmt.Fprintf(out, "%s_bucket{%s,le=\"+Inf\"} %d\n", family.name, key.labels, h.count)
+			fmt.Fprintf(out, "%s_sum{%s} %s\n", family.name, key.labels, formatFloat(h.sum))
+			fmt.Fprintf(out, "%s_count{%s} %d\n", family.name, key.labels, h.count)
+		}
+	}
+
+	return out.Flush()
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WritePrometheus(w)
+}
+
+func sortedSeries[V any](values map[series]V, family string) []series {
+	keys := make([]series, 0)
+	for key := range values {
+		if key.family == family {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].labels < keys[j].labels })
+	return keys
+}
+
+func queueLabels(queue string) string {
+	return `queue="` + escapeLabel(queue) + `"`
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
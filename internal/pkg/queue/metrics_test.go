@@ -0,0 +1,106 @@
+package queue_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Metrics", func() {
+	var metrics *queue.Metrics
+
+	BeforeEach(func() {
+		metrics = queue.NewMetricsWithBuckets([]float64{0.1, 1})
+	})
+
+	render := func() string {
+		var buf bytes.Buffer
+		Expect(metrics.WritePrometheus(&buf)).To(Succeed())
+		return buf.String()
+	}
+
+	It("should count events per queue", func() {
+		ctx := context.Background()
+		metrics.OnPublish(ctx, "investigate", queue.Metadata{}, nil)
+		metrics.OnPublish(ctx, "investigate", queue.Metadata{}, nil)
+		metrics.OnPublish(ctx, "investigate", queue.Metadata{}, errors.New("down"))
+		metrics.OnConsume(ctx, "investigate", queue.Metadata{})
+		metrics.OnHandled(ctx, "investigate", queue.Metadata{}, time.Millisecond, nil)
+		metrics.OnHandled(ctx, "investigate", queue.Metadata{}, time.Millisecond, errors.New("boom"))
+		metrics.OnRetry(ctx, "investigate", queue.Metadata{}, nil)
+		metrics.OnDeadLetter(ctx, "scrape", queue.Metadata{}, nil)
+
+		output := render()
+		Expect(output).To(ContainSubstring("# TYPE queue_messages_published_total counter\n"))
+		Expect(output).To(ContainSubstring(`queue_messages_published_total{queue="investigate"} 2`))
+		Expect(output).To(ContainSubstring(`queue_publish_errors_total{queue="investigate"} 1`))
+		Expect(output).To(ContainSubstring(`queue_messages_consumed_total{queue="investigate"} 1`))
+		Expect(output).To(ContainSubstring(`queue_messages_handled_total{queue="investigate",result="success"} 1`))
+		Expect(output).To(ContainSubstring(`queue_messages_handled_total{queue="investigate",result="failure"} 1`))
+		Expect(output).To(ContainSubstring(`queue_messages_retried_total{queue="investigate"} 1`))
+		Expect(output).To(ContainSubstring(`queue_messages_dead_lettered_total{queue="scrape"} 1`))
+	})
+
+	It("should record handler latency as a cumulative histogram", func() {
+		ctx := context.Background()
+		metrics.OnHandled(ctx, "investigate", queue.Metadata{}, 50*time.Millisecond, nil)
+		metrics.OnHandled(ctx, "investigate", queue.Metadata{}, 500*time.Millisecond, nil)
+		metrics.OnHandled(ctx, "investigate", queue.Metadata{}, 5*time.Second, nil)
+
+		output := render()
+		Expect(output).To(ContainSubstring("# TYPE queue_handler_duration_seconds histogram\n"))
+		Expect(output).To(ContainSubstring(`queue_handler_duration_seconds_bucket{queue="investigate",le="0.1"} 1`))
+		Expect(output).To(ContainSubstring(`queue_handler_duration_seconds_bucket{queue="investigate",le="1"} 2`))
+		Expect(output).To(ContainSubstring(`queue_handler_duration_seconds_bucket{queue="investigate",le="+Inf"} 3`))
+		Expect(output).To(ContainSubstring(`queue_handler_duration_seconds_sum{queue="investigate"} 5.55`))
+		Expect(output).To(ContainSubstring(`queue_handler_duration_seconds_count{queue="investigate"} 3`))
+	})
+
+	It("should escape label values", func() {
+		metrics.OnConsume(context.Background(), `odd"name`, queue.Metadata{})
+		Expect(render()).To(ContainSubstring(`queue_messages_consumed_total{queue="odd\"name"} 1`))
+	})
+
+	It("should serve the text format over HTTP", func() {
+		metrics.OnPublish(context.Background(), "investigate", queue.Metadata{}, nil)
+
+		recorder := httptest.NewRecorder()
+		metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+		Expect(recorder.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+		Expect(recorder.Body.String()).To(ContainSubstring(`queue_messages_published_total{queue="investigate"} 1`))
+	})
+
+	It("should observe a queue it is attached to", func() {
+		q := queue.NewMemoryQueue[string]("observed")
+		metrics := queue.NewMetrics()
+		q.AddHooks(metrics)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		defer q.Close()
+
+		Expect(q.Publish(ctx, "hello", nil)).To(Succeed())
+		handled := make(chan struct{}, 1)
+		go func() {
+			_ = q.Consume(ctx, func(context.Context, string) error {
+				handled <- struct{}{}
+				return nil
+			})
+		}()
+		Eventually(handled).Should(Receive())
+
+		Eventually(func() string {
+			var buf bytes.Buffer
+			_ = metrics.WritePrometheus(&buf)
+			return buf.String()
+		}).Should(ContainSubstring(`queue_handler_duration_seconds_count{queue="observed"} 1`))
+	})
+})
@@ -0,0 +1,68 @@
+package queue
+
+import "math/rand/v2"
+
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+var strictPriorityOrder = []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	default:
+		return "normal"
+	}
+}
+
+func (p Priority) normalize() Priority {
+	switch {
+	case p > PriorityHigh:
+		return PriorityHigh
+	case p < PriorityLow:
+		return PriorityLow
+	default:
+		return p
+	}
+}
+
+// priorityOrder returns the order in which priority levels are polled. Without
+// weights levels are strictly ordered; with weights the first level is drawn
+// at random proportionally to its weight and the others follow strictly, so
+// empty levels never leave a consumer idle.
+func priorityOrder(weights map[Priority]int) []Priority {
+	total := 0
+	for _, priority := range strictPriorityOrder {
+		total += max(weights[priority], 0)
+	}
+	if total == 0 {
+		return strictPriorityOrder
+	}
+
+	pick := rand.IntN(total)
+	first := PriorityNormal
+	for _, priority := range strictPriorityOrder {
+		pick -= max(weights[priority], 0)
+		if pick < 0 {
+			first = priority
+			break
+		}
+	}
+
+	order := make([]Priority, 0, len(strictPriorityOrder))
+	order = append(order, first)
+	for _, priority := range strictPriorityOrder {
+		if priority != first {
+			order = append(order, priority)
+		}
+	}
+	return order
+}
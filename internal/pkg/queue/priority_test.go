@@ -0,0 +1,149 @@
+package queue_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Priority", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	consumeN := func(q queue.ContextQueue[string], n int) []string {
+		received := make(chan string, n)
+		consumerCtx, stop := context.WithCancel(ctx)
+		defer stop()
+
+		go func() {
+			_ = q.Consume(consumerCtx, func(_ context.Context, msg string) error {
+				received <- msg
+				if len(received) == n {
+					stop()
+				}
+				return nil
+			})
+		}()
+
+		msgs := make([]string, 0, n)
+		for range n {
+			var msg string
+			Eventually(received, 3*time.Second).Should(Receive(&msg))
+			msgs = append(msgs, msg)
+		}
+		return msgs
+	}
+
+	It("should have readable names", func() {
+		Expect(queue.PriorityHigh.String()).To(Equal("high"))
+		Expect(queue.PriorityNormal.String()).To(Equal("normal"))
+		Expect(queue.PriorityLow.String()).To(Equal("low"))
+	})
+
+	Context("with the memory queue", func() {
+		var q *queue.MemoryQueue[string]
+
+		BeforeEach(func() {
+			q = queue.NewMemoryQueue[string]("investigate")
+		})
+
+		AfterEach(func() {
+			Expect(q.Close()).To(Succeed())
+		})
+
+		It("should consume higher priorities first by default", func() {
+			Expect(q.Publish(ctx, "bulk", &queue.PublishOptions{Priority: queue.PriorityLow})).To(Succeed())
+			Expect(q.Publish(ctx, "crawl", nil)).To(Succeed())
+			Expect(q.Publish(ctx, "analyst", &queue.PublishOptions{Priority: queue.PriorityHigh})).To(Succeed())
+
+			Expect(consumeN(q, 3)).To(Equal([]string{"analyst", "crawl", "bulk"}))
+		})
+
+		It("should expose the priority in the handler metadata", func() {
+			Expect(q.Publish(ctx, "analyst", &queue.PublishOptions{Priority: queue.PriorityHigh})).To(Succeed())
+
+			priorities := make(chan queue.Priority, 1)
+			go func() {
+				_ = q.Consume(ctx, func(handlerCtx context.Context, _ string) error {
+					metadata, _ := queue.MetadataFromContext(handlerCtx)
+					priorities <- metadata.Priority
+					return nil
+				})
+			}()
+
+			Eventually(priorities).Should(Receive(Equal(queue.PriorityHigh)))
+		})
+
+		It("should interleave priorities when weighted", func() {
+			q.SetPriorityWeights(map[queue.Priority]int{queue.PriorityHigh: 1, queue.PriorityLow: 1})
+			for range 50 {
+				Expect(q.Publish(ctx, "high", &queue.PublishOptions{Priority: queue.PriorityHigh})).To(Succeed())
+				Expect(q.Publish(ctx, "low", &queue.PublishOptions{Priority: queue.PriorityLow})).To(Succeed())
+			}
+
+			Expect(consumeN(q, 50)).To(ContainElements("high", "low"))
+		})
+	})
+
+	Context("with the Redis queue", func() {
+		var (
+			server *miniredis.Miniredis
+			q      *queue.RedisQueue[string]
+		)
+
+		BeforeEach(func() {
+			server = miniredis.RunT(GinkgoT())
+
+			var err error
+			q, err = queue.NewRedisQueue[string](server.Addr(), "", queue.Investigate)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			_ = q.Close()
+		})
+
+		It("should store each priority under its own key", func() {
+			Expect(q.Publish(ctx, "analyst", &queue.PublishOptions{Priority: queue.PriorityHigh})).To(Succeed())
+			Expect(q.Publish(ctx, "crawl", nil)).To(Succeed())
+			Expect(q.Publish(ctx, "bulk", &queue.PublishOptions{Priority: queue.PriorityLow})).To(Succeed())
+
+			Expect(server.Exists("investigate_high")).To(BeTrue())
+			Expect(server.Exists("investigate")).To(BeTrue())
+			Expect(server.Exists("investigate_low")).To(BeTrue())
+		})
+
+		It("should consume higher priorities first by default", func() {
+			Expect(q.Publish(ctx, "bulk", &queue.PublishOptions{Priority: queue.PriorityLow})).To(Succeed())
+			Expect(q.Publish(ctx, "crawl", nil)).To(Succeed())
+			Expect(q.Publish(ctx, "analyst", &queue.PublishOptions{Priority: queue.PriorityHigh})).To(Succeed())
+
+			Expect(consumeN(q, 3)).To(Equal([]string{"analyst", "crawl", "bulk"}))
+		})
+
+		It("should interleave priorities when weighted", func() {
+			q.SetPriorityWeights(map[queue.Priority]int{queue.PriorityHigh: 1, queue.PriorityLow: 1})
+			for range 50 {
+				Expect(q.Publish(ctx, "high", &queue.PublishOptions{Priority: queue.PriorityHigh})).To(Succeed())
+				Expect(q.Publish(ctx, "low", &queue.PublishOptions{Priority: queue.PriorityLow})).To(Succeed())
+			}
+
+			Expect(consumeN(q, 50)).To(ContainElements("high", "low"))
+		})
+	})
+})
@@ -2,89 +2,170 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log"
-	"mmm-osint/internal/pkg/env"
 	"time"
 
+	"mmm-osint/internal/pkg/redisconn"
+
 	"github.com/redis/go-redis/v9"
 )
 
+const scheduleBatchSize = 100
+
+var moveDueScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, data in ipairs(due) do
+	redis.call('ZREM', KEYS[1], data)
+	redis.call('LPUSH', KEYS[2], data)
+end
+return #due
+`)
+
 type RedisQueue[T any] struct {
-	client    *redis.Client
-	queueName string
-	ctx       context.Context
-	cancel    context.CancelFunc
+	core[T]
+	client            redis.UniversalClient
+	ownsClient        bool
+	queueName         string
+	schedulerInterval time.Duration
+	heartbeatInterval time.Duration
 }
 
 func NewRedisQueue[T any](uri string, password string, queueName QueueName) (*RedisQueue[T], error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     uri,
+	return NewRedisQueueWithConfig[T](&redisconn.Config{
+		Addrs:    []string{uri},
 		Password: password,
-		DB:       0,
-	})
+	}, queueName)
+}
+
+func NewRedisQueueWithConfig[T any](config *redisconn.Config, queueName QueueName) (*RedisQueue[T], error) {
+	client, err := redisconn.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	q := NewRedisQueueWithClient[T](client, queueName)
+	q.ownsClient = true
 
-	if err := client.Ping(ctx).Err(); err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+	return q, nil
+}
+
+// NewRedisQueueWithClient uses a client shared with other queues or caches.
+// Close leaves the client open.
+func NewRedisQueueWithClient[T any](client redis.UniversalClient, queueName QueueName) *RedisQueue[T] {
+	q := &RedisQueue[T]{
+		client:            client,
+		queueName:         keyBase(client, queueName),
+		schedulerInterval: 1 * time.Second,
+		heartbeatInterval: 5 * time.Second,
 	}
+	q.core = newCore[T](string(queueName), q)
 
-	return &RedisQueue[T]{
-		client:    client,
-		queueName: string(queueName),
-		ctx:       ctx,
-		cancel:    cancel,
-	}, nil
+	return q
 }
 
-func (r *RedisQueue[T]) PublishMessage(msg T) error {
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %v", err)
+// keyBase is the prefix of every key of a queue. On a cluster it is a hash
+// tag so that the lists, schedules and replies of a queue share a slot and
+// can be used together in scripts, transactions and BRPOP.
+func keyBase(client redis.UniversalClient, queueName QueueName) string {
+	if redisconn.IsCluster(client) {
+		return "{" + string(queueName) + "}"
 	}
+	return string(queueName)
+}
 
-	return r.client.LPush(r.ctx, r.queueName, data).Err()
+func (r *RedisQueue[T]) SetSchedulerInterval(interval time.Duration) {
+	r.schedulerInterval = interval
 }
 
-func (r *RedisQueue[T]) ConsumeMessages(handler func(T) error) error {
-	for {
-		if r.ctx.Err() != nil {
-			return r.ctx.Err()
-		}
+func (r *RedisQueue[T]) listKey(priority Priority) string {
+	if priority == PriorityNormal {
+		return r.queueName
+	}
+	return r.queueName + "_" + priority.String()
+}
 
-		result, err := r.client.BRPop(r.ctx, 1*time.Second, r.queueName).Result()
+func (r *RedisQueue[T]) scheduledKey(priority Priority) string {
+	return r.listKey(priority) + "_scheduled"
+}
 
-		if err != nil {
-			if err == redis.Nil {
-				continue
-			}
-			if err == context.Canceled {
-				return nil
-			}
-			log.Printf("Error getting message from queue (%s): %v", r.queueName, err)
-			continue
+func (r *RedisQueue[T]) push(ctx context.Context, priority Priority, data []byte) error {
+	return r.client.LPush(ctx, r.listKey(priority), data).Err()
+}
+
+func (r *RedisQueue[T]) schedule(ctx context.Context, priority Priority, data []byte, at time.Time) error {
+	return r.client.ZAdd(ctx, r.scheduledKey(priority), redis.Z{
+		Score:  float64(at.UnixMilli()),
+		Member: data,
+	}).Err()
+}
+
+func (r *RedisQueue[T]) deadLetterKey() string {
+	return r.queueName + "_dead"
+}
+
+func (r *RedisQueue[T]) deadLetter(ctx context.Context, data []byte) error {
+	return r.client.LPush(ctx, r.deadLetterKey(), data).Err()
+}
+
+func (r *RedisQueue[T]) pop(ctx context.Context, order []Priority) ([]byte, error) {
+	keys := make([]string, len(order))
+	for i, priority := range order {
+		keys[i] = r.listKey(priority)
+	}
+
+	result, err := r.client.BRPop(ctx, 1*time.Second, keys...).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, errNoMessage
 		}
+		return nil, err
+	}
 
-		payload := result[1]
+	return []byte(result[1]), nil
+}
+
+func (r *RedisQueue[T]) runScheduler(ctx context.Context) {
+	ticker := time.NewTicker(r.schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.moveDueMessages(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error moving scheduled messages for queue (%s): %v", r.name, err)
+		}
 
-		var msg T
-		if err := json.Unmarshal([]byte(payload), &msg); err != nil {
-			log.Printf("Error unmarshaling message: %v", err)
-			continue
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
+	}
+}
 
-		if err := handler(msg); err != nil {
-			log.Printf("Error processing message for queue (%s - worker : %s): %v", r.queueName, env.GetHostName(), err)
-			// r.client.LPush(r.ctx, r.queueName, payload)
-			continue
+func (r *RedisQueue[T]) moveDueMessages(ctx context.Context) error {
+	for _, priority := range strictPriorityOrder {
+		for {
+			moved, err := moveDueScript.Run(ctx, r.client,
+				[]string{r.scheduledKey(priority), r.listKey(priority)},
+				time.Now().UnixMilli(), scheduleBatchSize,
+			).Int()
+			if err != nil {
+				return err
+			}
+			if moved < scheduleBatchSize {
+				break
+			}
 		}
 	}
+	return nil
 }
 
+// Close cancels the consumers and waits for them to push back the messages
+// they fetched ahead before closing the client. Call Shutdown first to let
+// handlers finish instead.
 func (r *RedisQueue[T]) Close() error {
-	r.cancel()
+	r.stop()
+	if !r.ownsClient {
+		return nil
+	}
 	return r.client.Close()
 }
@@ -0,0 +1,209 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func (r *RedisQueue[T]) SetHeartbeatInterval(interval time.Duration) {
+	r.heartbeatInterval = interval
+}
+
+func (r *RedisQueue[T]) consumersKey() string {
+	return r.queueName + "_consumers"
+}
+
+func (r *RedisQueue[T]) trackConsumer(ctx context.Context, c *consumer) {
+	if err := r.pruneConsumers(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("Error removing expired consumers for queue (%s): %v", r.name, err)
+	}
+
+	ticker := time.NewTicker(r.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		data, err := json.Marshal(c.info(r.heartbeatInterval))
+		if err == nil {
+			err = r.client.HSet(ctx, r.consumersKey(), c.id, data).Err()
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error registering consumer %s for queue (%s): %v", c.id, r.name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			r.client.HDel(cleanupCtx, r.consumersKey(), c.id)
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Length counts the messages waiting in the queue, scheduled ones included,
+// which are the messages Purge removes.
+func (r *RedisQueue[T]) Length(ctx context.Context) (int64, error) {
+	pipe := r.client.Pipeline()
+	lengths := make([]*redis.IntCmd, 0, 2*len(strictPriorityOrder))
+	for _, priority := range strictPriorityOrder {
+		lengths = append(lengths, pipe.LLen(ctx, r.listKey(priority)))
+		lengths = append(lengths, pipe.ZCard(ctx, r.scheduledKey(priority)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, length := range lengths {
+		total += length.Val()
+	}
+	return total, nil
+}
+
+// Peek returns up to n messages in the order consumers would receive them
+// under strict priority, without removing them.
+func (r *RedisQueue[T]) Peek(ctx context.Context, n int) ([]Envelope, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid message count: %d", n)
+	}
+	envelopes := make([]Envelope, 0, n)
+
+	for _, priority := range strictPriorityOrder {
+		remaining := n - len(envelopes)
+		if remaining <= 0 {
+			break
+		}
+
+		items, err := r.client.LRange(ctx, r.listKey(priority), int64(-remaining), -1).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(items) - 1; i >= 0; i-- {
+			envelopes = append(envelopes, *parseEnvelope([]byte(items[i])))
+		}
+	}
+
+	return envelopes, nil
+}
+
+// Purge deletes every ready and scheduled message and returns their count.
+func (r *RedisQueue[T]) Purge(ctx context.Context) (int64, error) {
+	keys := make([]string, 0, 2*len(strictPriorityOrder))
+	counts := make([]*redis.IntCmd, 0, 2*len(strictPriorityOrder))
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, priority := range strictPriorityOrder {
+			counts = append(counts, pipe.LLen(ctx, r.listKey(priority)))
+			counts = append(counts, pipe.ZCard(ctx, r.scheduledKey(priority)))
+			keys = append(keys, r.listKey(priority), r.scheduledKey(priority))
+		}
+		pipe.Del(ctx, keys...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, count := range counts {
+		total += count.Val()
+	}
+	return total, nil
+}
+
+// ListConsumers returns the consumers whose last heartbeat has not expired.
+// Consumers that stopped without deregistering are left out, and removed by
+// the next consumer to start.
+func (r *RedisQueue[T]) ListConsumers(ctx context.Context) ([]ConsumerInfo, error) {
+	entries, err := r.client.HGetAll(ctx, r.consumersKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	consumers := make([]ConsumerInfo, 0, len(entries))
+	for _, data := range entries {
+		var info ConsumerInfo
+		if err := json.Unmarshal([]byte(data), &info); err != nil || r.consumerExpired(info, now) {
+			continue
+		}
+		consumers = append(consumers, info)
+	}
+
+	return consumers, nil
+}
+
+func (r *RedisQueue[T]) pruneConsumers(ctx context.Context) error {
+	entries, err := r.client.HGetAll(ctx, r.consumersKey()).Result()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var expired []string
+	for id, data := range entries {
+		var info ConsumerInfo
+		if err := json.Unmarshal([]byte(data), &info); err != nil || r.consumerExpired(info, now) {
+			expired = append(expired, id)
+		}
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+	return r.client.HDel(ctx, r.consumersKey(), expired...).Err()
+}
+
+// consumerExpired judges registrations that do not say when they expire by
+// the heartbeat interval of this queue.
+func (r *RedisQueue[T]) consumerExpired(info ConsumerInfo, now time.Time) bool {
+	expiresAt := info.ExpiresAt
+	if expiresAt.IsZero() {
+		expiresAt = info.LastSeen.Add(3 * r.heartbeatInterval)
+	}
+	return !now.Before(expiresAt)
+}
+
+func (r *RedisQueue[T]) InFlight(ctx context.Context) (int64, error) {
+	consumers, err := r.ListConsumers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, info := range consumers {
+		total += info.InFlight
+	}
+	return total, nil
+}
+
+func (r *RedisQueue[T]) OldestMessageAge(ctx context.Context) (time.Duration, error) {
+	var oldest time.Time
+
+	for _, priority := range strictPriorityOrder {
+		data, err := r.client.LIndex(ctx, r.listKey(priority), -1).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		createdAt := parseEnvelope([]byte(data)).CreatedAt
+		if !createdAt.IsZero() && (oldest.IsZero() || createdAt.Before(oldest)) {
+			oldest = createdAt
+		}
+	}
+
+	if oldest.IsZero() {
+		return 0, nil
+	}
+	return time.Since(oldest), nil
+}
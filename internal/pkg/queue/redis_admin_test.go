@@ -0,0 +1,188 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/env"
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Redis Queue Admin", func() {
+	var (
+		server *miniredis.Miniredis
+		q      *queue.RedisQueue[string]
+		admin  queue.Admin
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		server = miniredis.RunT(GinkgoT())
+
+		var err error
+		q, err = queue.NewRedisQueue[string](server.Addr(), "", queue.Investigate)
+		Expect(err).NotTo(HaveOccurred())
+		q.SetHeartbeatInterval(50 * time.Millisecond)
+		admin = q
+
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		cancel()
+		_ = q.Close()
+	})
+
+	Describe("Length", func() {
+		It("should count ready and scheduled messages across priorities", func() {
+			Expect(q.Publish(ctx, "a", nil)).To(Succeed())
+			Expect(q.Publish(ctx, "b", &queue.PublishOptions{Priority: queue.PriorityHigh})).To(Succeed())
+			Expect(q.PublishAfter(ctx, "c", time.Hour, nil)).To(Succeed())
+
+			length, err := admin.Length(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(length).To(Equal(int64(3)))
+		})
+	})
+
+	Describe("Peek", func() {
+		It("should return the next messages without consuming them", func() {
+			Expect(q.Publish(ctx, "first", nil)).To(Succeed())
+			Expect(q.Publish(ctx, "second", nil)).To(Succeed())
+			Expect(q.Publish(ctx, "urgent", &queue.PublishOptions{Priority: queue.PriorityHigh})).To(Succeed())
+
+			envelopes, err := admin.Peek(ctx, 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(envelopes).To(HaveLen(2))
+			Expect(string(envelopes[0].Payload)).To(Equal(`"urgent"`))
+			Expect(string(envelopes[1].Payload)).To(Equal(`"first"`))
+
+			length, _ := admin.Length(ctx)
+			Expect(length).To(Equal(int64(3)))
+		})
+
+		It("should reject negative counts", func() {
+			_, err := admin.Peek(ctx, -1)
+			Expect(err).To(MatchError("invalid message count: -1"))
+		})
+	})
+
+	Describe("Purge", func() {
+		It("should remove ready and scheduled messages", func() {
+			Expect(q.Publish(ctx, "a", nil)).To(Succeed())
+			Expect(q.Publish(ctx, "b", &queue.PublishOptions{Priority: queue.PriorityLow})).To(Succeed())
+			Expect(q.PublishAfter(ctx, "c", time.Hour, nil)).To(Succeed())
+
+			purged, err := admin.Purge(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(purged).To(Equal(int64(3)))
+
+			length, _ := admin.Length(ctx)
+			Expect(length).To(BeZero())
+			Expect(server.Exists("investigate_scheduled")).To(BeFalse())
+		})
+	})
+
+	Describe("OldestMessageAge", func() {
+		It("should be zero for an empty queue", func() {
+			age, err := admin.OldestMessageAge(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(age).To(BeZero())
+		})
+
+		It("should report the age of the next message", func() {
+			Expect(q.Publish(ctx, "old", nil)).To(Succeed())
+			time.Sleep(50 * time.Millisecond)
+			Expect(q.Publish(ctx, "new", nil)).To(Succeed())
+
+			age, err := admin.OldestMessageAge(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(age).To(BeNumerically(">=", 50*time.Millisecond))
+		})
+	})
+
+	Describe("consumers", func() {
+		It("should list running consumers and their in-flight messages", func() {
+			release := make(chan struct{})
+			go func() {
+				_ = q.Consume(ctx, func(context.Context, string) error {
+					<-release
+					return nil
+				})
+			}()
+			defer close(release)
+
+			Expect(q.Publish(ctx, "slow", nil)).To(Succeed())
+
+			Eventually(func() int64 {
+				inFlight, _ := admin.InFlight(ctx)
+				return inFlight
+			}, 3*time.Second).Should(Equal(int64(1)))
+
+			consumers, err := admin.ListConsumers(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(consumers).To(HaveLen(1))
+			Expect(consumers[0].Host).To(Equal(env.GetHostName()))
+			Expect(consumers[0].InFlight).To(Equal(int64(1)))
+		})
+
+		It("should deregister consumers when they stop", func() {
+			consumerCtx, stop := context.WithCancel(ctx)
+			go func() {
+				_ = q.Consume(consumerCtx, func(context.Context, string) error { return nil })
+			}()
+
+			Eventually(func() []queue.ConsumerInfo {
+				consumers, _ := admin.ListConsumers(ctx)
+				return consumers
+			}, 3*time.Second).Should(HaveLen(1))
+
+			stop()
+
+			Eventually(func() []queue.ConsumerInfo {
+				consumers, _ := admin.ListConsumers(ctx)
+				return consumers
+			}, 3*time.Second).Should(BeEmpty())
+		})
+
+		It("should leave out consumers that stopped sending heartbeats", func() {
+			server.HSet("investigate_consumers", "gone", `{"id":"gone","last_seen":"2020-01-01T00:00:00Z","expires_at":"2020-01-01T00:00:15Z"}`)
+
+			consumers, err := admin.ListConsumers(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(consumers).To(BeEmpty())
+			Expect(server.HKeys("investigate_consumers")).To(ConsistOf("gone"))
+		})
+
+		It("should judge consumers by their own heartbeat interval", func() {
+			lastSeen := time.Now().Add(-time.Second).UTC()
+			data, err := json.Marshal(queue.ConsumerInfo{ID: "slow", LastSeen: lastSeen, ExpiresAt: lastSeen.Add(time.Minute)})
+			Expect(err).NotTo(HaveOccurred())
+			server.HSet("investigate_consumers", "slow", string(data))
+
+			consumers, err := admin.ListConsumers(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(consumers).To(HaveLen(1))
+			Expect(consumers[0].ID).To(Equal("slow"))
+		})
+
+		It("should remove expired consumers when another one starts", func() {
+			server.HSet("investigate_consumers", "gone", `{"id":"gone","last_seen":"2020-01-01T00:00:00Z","expires_at":"2020-01-01T00:00:15Z"}`)
+
+			go func() {
+				_ = q.Consume(ctx, func(context.Context, string) error { return nil })
+			}()
+
+			Eventually(func() []string {
+				keys, _ := server.HKeys("investigate_consumers")
+				return keys
+			}, 3*time.Second).Should(SatisfyAll(HaveLen(1), Not(ContainElement("gone"))))
+		})
+	})
+})
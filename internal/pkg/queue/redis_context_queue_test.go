@@ -0,0 +1,322 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+
+	"mmm-osint/internal/pkg/cache"
+	"mmm-osint/internal/pkg/env"
+	"mmm-osint/internal/pkg/queue"
+	"mmm-osint/internal/pkg/redisconn"
+)
+
+// failingPops fails and counts every BRPOP, standing in for a Redis outage.
+type failingPops struct {
+	count atomic.Int32
+}
+
+func (f *failingPops) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (f *failingPops) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() != "brpop" {
+			return next(ctx, cmd)
+		}
+		f.count.Add(1)
+		err := errors.New("connection refused")
+		cmd.SetErr(err)
+		return err
+	}
+}
+
+func (f *failingPops) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+var _ = Describe("Redis Queue with context", func() {
+	type job struct {
+		URL string `json:"url"`
+	}
+
+	type delivery struct {
+		msg      job
+		metadata queue.Metadata
+		ctx      context.Context
+	}
+
+	var (
+		server     *miniredis.Miniredis
+		q          *queue.RedisQueue[job]
+		ctx        context.Context
+		cancel     context.CancelFunc
+		deliveries chan delivery
+		consuming  bool
+	)
+
+	BeforeEach(func() {
+		server = miniredis.RunT(GinkgoT())
+
+		var err error
+		q, err = queue.NewRedisQueue[job](server.Addr(), "", "jobs")
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx, cancel = context.WithCancel(context.Background())
+		deliveries = make(chan delivery, 10)
+		consuming = false
+	})
+
+	AfterEach(func() {
+		cancel()
+		_ = q.Close()
+	})
+
+	consumeOne := func() (job, queue.Metadata, context.Context) {
+		if !consuming {
+			consuming = true
+			go func() {
+				defer GinkgoRecover()
+				_ = q.Consume(ctx, func(handlerCtx context.Context, msg job) error {
+					metadata, ok := queue.MetadataFromContext(handlerCtx)
+					Expect(ok).To(BeTrue())
+					deliveries <- delivery{msg: msg, metadata: metadata, ctx: handlerCtx}
+					return nil
+				})
+			}()
+		}
+
+		var d delivery
+		Eventually(deliveries, 3*time.Second).Should(Receive(&d))
+		return d.msg, d.metadata, d.ctx
+	}
+
+	It("should deliver published messages with metadata", func() {
+		Expect(q.Publish(ctx, job{URL: "https://example.com"}, nil)).To(Succeed())
+
+		msg, metadata, _ := consumeOne()
+		Expect(msg.URL).To(Equal("https://example.com"))
+		Expect(metadata.ID).NotTo(BeEmpty())
+		Expect(metadata.Attempt).To(Equal(1))
+		Expect(metadata.OriginHost).To(Equal(env.GetHostName()))
+		Expect(metadata.EnqueuedAt).To(BeTemporally("~", time.Now(), 5*time.Second))
+	})
+
+	It("should apply the message deadline to the handler context", func() {
+		deadline := time.Now().Add(time.Minute)
+		Expect(q.Publish(ctx, job{URL: "https://example.com"}, &queue.PublishOptions{Deadline: deadline})).To(Succeed())
+
+		_, metadata, handlerCtx := consumeOne()
+		Expect(metadata.Deadline).To(BeTemporally("~", deadline, time.Millisecond))
+
+		handlerDeadline, ok := handlerCtx.Deadline()
+		Expect(ok).To(BeTrue())
+		Expect(handlerDeadline).To(BeTemporally("~", deadline, time.Millisecond))
+	})
+
+	It("should skip messages whose deadline has passed", func() {
+		Expect(q.Publish(ctx, job{URL: "https://expired.example.com"}, &queue.PublishOptions{Deadline: time.Now().Add(-time.Second)})).To(Succeed())
+		Expect(q.Publish(ctx, job{URL: "https://fresh.example.com"}, nil)).To(Succeed())
+
+		msg, _, _ := consumeOne()
+		Expect(msg.URL).To(Equal("https://fresh.example.com"))
+	})
+
+	It("should consume bare payloads published before messages were wrapped", func() {
+		payload, _ := json.Marshal(job{URL: "https://legacy.example.com"})
+		_, err := server.Lpush("jobs", string(payload))
+		Expect(err).NotTo(HaveOccurred())
+
+		msg, metadata, _ := consumeOne()
+		Expect(msg.URL).To(Equal("https://legacy.example.com"))
+		Expect(metadata.Attempt).To(Equal(1))
+	})
+
+	It("should stop consuming when the context is cancelled", func() {
+		done := make(chan error, 1)
+		go func() {
+			done <- q.Consume(ctx, func(context.Context, job) error { return nil })
+		}()
+
+		cancel()
+		Eventually(done, 3*time.Second).Should(Receive(MatchError(context.Canceled)))
+	})
+
+	It("should stop consuming when the queue is closed", func() {
+		done := make(chan error, 1)
+		go func() {
+			done <- q.ConsumeMessages(func(job) error { return nil })
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		Expect(q.Close()).To(Succeed())
+		Eventually(done, 3*time.Second).Should(Receive(BeNil()))
+	})
+
+	It("should push messages fetched ahead back to the queue before closing", func() {
+		for _, url := range []string{"https://example.com/1", "https://example.com/2", "https://example.com/3"} {
+			Expect(q.Publish(ctx, job{URL: url}, nil)).To(Succeed())
+		}
+
+		started := make(chan struct{}, 1)
+		go func() {
+			_ = q.ConsumeFair(ctx, func(job) string { return "example.com" }, nil, func(handlerCtx context.Context, _ job) error {
+				started <- struct{}{}
+				<-handlerCtx.Done()
+				return handlerCtx.Err()
+			})
+		}()
+
+		Eventually(started, 3*time.Second).Should(Receive())
+		Eventually(func() bool { return server.Exists("jobs") }).Should(BeFalse())
+
+		Expect(q.Close()).To(Succeed())
+		Expect(server.List("jobs")).To(HaveLen(2))
+	})
+
+	It("should move messages that keep failing to the dead letter list", func() {
+		q.SetRetryPolicy(&queue.RetryPolicy{MaxAttempts: 2, DeadLetter: true})
+		Expect(q.Publish(ctx, job{URL: "https://broken.example.com"}, nil)).To(Succeed())
+
+		go func() {
+			_ = q.Consume(ctx, func(context.Context, job) error {
+				return errors.New("boom")
+			})
+		}()
+
+		Eventually(func() ([]string, error) {
+			return server.List("jobs_dead")
+		}, 3*time.Second).Should(HaveLen(1))
+
+		dead, _ := server.List("jobs_dead")
+		var envelope queue.Envelope
+		Expect(json.Unmarshal([]byte(dead[0]), &envelope)).To(Succeed())
+		Expect(envelope.Attempt).To(Equal(2))
+		Expect(envelope.Headers).To(HaveKeyWithValue(queue.HeaderLastError, "boom"))
+	})
+
+	Describe("scheduled delivery", func() {
+		BeforeEach(func() {
+			q.SetSchedulerInterval(20 * time.Millisecond)
+		})
+
+		It("should hold delayed messages in a sorted set until they are due", func() {
+			Expect(q.PublishAfter(ctx, job{URL: "https://later.example.com"}, time.Hour, nil)).To(Succeed())
+
+			Expect(server.Exists("jobs")).To(BeFalse())
+			members, err := server.ZMembers("jobs_scheduled")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(members).To(HaveLen(1))
+		})
+
+		It("should deliver delayed messages once they are due", func() {
+			start := time.Now()
+			Expect(q.PublishAfter(ctx, job{URL: "https://later.example.com"}, 200*time.Millisecond, nil)).To(Succeed())
+			Expect(q.Publish(ctx, job{URL: "https://now.example.com"}, nil)).To(Succeed())
+
+			msg, _, _ := consumeOne()
+			Expect(msg.URL).To(Equal("https://now.example.com"))
+
+			msg, _, _ = consumeOne()
+			Expect(msg.URL).To(Equal("https://later.example.com"))
+			Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+		})
+
+		It("should keep scheduled messages across queue instances", func() {
+			producer, err := queue.NewRedisQueue[job](server.Addr(), "", "jobs")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(producer.PublishAt(ctx, job{URL: "https://restart.example.com"}, time.Now().Add(50*time.Millisecond), nil)).To(Succeed())
+			Expect(producer.Close()).To(Succeed())
+
+			msg, _, _ := consumeOne()
+			Expect(msg.URL).To(Equal("https://restart.example.com"))
+
+			Expect(server.Exists("jobs_scheduled")).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Redis Queue with a shared client", func() {
+	var (
+		server *miniredis.Miniredis
+		client *redis.Client
+		ctx    context.Context
+	)
+
+	BeforeEach(func() {
+		server = miniredis.RunT(GinkgoT())
+		client = redis.NewClient(&redis.Options{Addr: server.Addr()})
+		ctx = context.Background()
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).To(Succeed())
+	})
+
+	It("should share the client between queues and caches", func() {
+		first := queue.NewRedisQueueWithClient[string](client, "first")
+		second := queue.NewRedisQueueWithClient[string](client, "second")
+		store := cache.NewRedisCacheWithClient(client)
+
+		Expect(first.Publish(ctx, "a", nil)).To(Succeed())
+		Expect(second.Publish(ctx, "b", nil)).To(Succeed())
+		Expect(store.Set(ctx, "key", "value", 0)).To(Succeed())
+
+		Expect(server.List("first")).To(HaveLen(1))
+		Expect(server.List("second")).To(HaveLen(1))
+		Expect(server.Exists("key")).To(BeTrue())
+	})
+
+	It("should leave a shared client open on Close", func() {
+		q := queue.NewRedisQueueWithClient[string](client, "jobs")
+		Expect(q.Close()).To(Succeed())
+		Expect(cache.NewRedisCacheWithClient(client).Close()).To(Succeed())
+
+		Expect(client.Ping(ctx).Err()).To(Succeed())
+	})
+
+	It("should back off while Redis keeps failing", func() {
+		pops := &failingPops{}
+		client.AddHook(pops)
+		q := queue.NewRedisQueueWithClient[string](client, "jobs")
+		DeferCleanup(q.Close)
+
+		go func() {
+			_ = q.Consume(ctx, func(context.Context, string) error { return nil })
+		}()
+
+		Eventually(pops.count.Load).Should(BeNumerically(">=", 2))
+		Consistently(pops.count.Load, 500*time.Millisecond).Should(BeNumerically("<", 10))
+	})
+
+	It("should back off while Redis keeps failing during fair consumption", func() {
+		pops := &failingPops{}
+		client.AddHook(pops)
+		q := queue.NewRedisQueueWithClient[string](client, "jobs")
+		DeferCleanup(q.Close)
+
+		go func() {
+			_ = q.ConsumeFair(ctx, func(msg string) string { return msg }, nil, func(context.Context, string) error { return nil })
+		}()
+
+		Eventually(pops.count.Load).Should(BeNumerically(">=", 2))
+		Consistently(pops.count.Load, 500*time.Millisecond).Should(BeNumerically("<", 10))
+	})
+
+	It("should connect from a configuration and close its own client", func() {
+		q, err := queue.NewRedisQueueWithConfig[string](&redisconn.Config{Addrs: []string{server.Addr()}}, "jobs")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(q.Publish(ctx, "a", nil)).To(Succeed())
+		Expect(server.List("jobs")).To(HaveLen(1))
+		Expect(q.Close()).To(Succeed())
+	})
+})
@@ -2,17 +2,45 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	"mmm-osint/internal/pkg/cache"
+	"mmm-osint/internal/pkg/redisconn"
+
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
+const cancelTTL = 10 * time.Minute
+
+// requestPayload and responsePayload mirror RequestMessage and
+// ResponseMessage with typed data, so payloads are decoded once straight
+// into T and R instead of going through any.
+type requestPayload[T any] struct {
+	ID       string     `json:"id"`
+	ReplyTo  string     `json:"reply_to"`
+	Data     T          `json:"data"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+type responsePayload[R any] struct {
+	ID          string       `json:"id"`
+	Data        R            `json:"data"`
+	Error       string       `json:"error,omitempty"`
+	RemoteError *RemoteError `json:"remote_error,omitempty"`
+	Partial     bool         `json:"partial,omitempty"`
+}
+
 type RedisRequestResponseQueue[T any, R any] struct {
 	*RedisQueue[RequestMessage]
+	cancelPollInterval time.Duration
+	replyTTL           time.Duration
+	replyMode          ReplyMode
+	dispatcher         *replyDispatcher
+	replies            *deduplicator
 }
 
 func NewRedisRequestResponseQueue[T any, R any](uri string, password string, queueName QueueName) (*RedisRequestResponseQueue[T, R], error) {
@@ -20,113 +48,356 @@ func NewRedisRequestResponseQueue[T any, R any](uri string, password string, que
 	if err != nil {
 		return nil, err
 	}
+	return newRedisRequestResponseQueue[T, R](baseQueue), nil
+}
+
+func NewRedisRequestResponseQueueWithConfig[T any, R any](config *redisconn.Config, queueName QueueName) (*RedisRequestResponseQueue[T, R], error) {
+	baseQueue, err := NewRedisQueueWithConfig[RequestMessage](config, queueName)
+	if err != nil {
+		return nil, err
+	}
+	return newRedisRequestResponseQueue[T, R](baseQueue), nil
+}
+
+func NewRedisRequestResponseQueueWithClient[T any, R any](client redis.UniversalClient, queueName QueueName) *RedisRequestResponseQueue[T, R] {
+	return newRedisRequestResponseQueue[T, R](NewRedisQueueWithClient[RequestMessage](client, queueName))
+}
+
+func newRedisRequestResponseQueue[T any, R any](baseQueue *RedisQueue[RequestMessage]) *RedisRequestResponseQueue[T, R] {
+	decode := func(data []byte, v any) error {
+		return baseQueue.format.codec.Unmarshal(data, v)
+	}
 
 	return &RedisRequestResponseQueue[T, R]{
-		RedisQueue: baseQueue,
-	}, nil
+		RedisQueue:         baseQueue,
+		cancelPollInterval: 500 * time.Millisecond,
+		replyTTL:           5 * time.Minute,
+		dispatcher:         newReplyDispatcher(baseQueue.client, baseQueue.queueName+"_replies_"+uuid.New().String(), decode),
+	}
+}
+
+func (r *RedisRequestResponseQueue[T, R]) SetCancelPollInterval(interval time.Duration) {
+	r.cancelPollInterval = interval
+}
+
+func (r *RedisRequestResponseQueue[T, R]) SetReplyMode(mode ReplyMode) {
+	r.replyMode = mode
+}
+
+func (r *RedisRequestResponseQueue[T, R]) SetReplyTTL(ttl time.Duration) {
+	r.replyTTL = ttl
+}
+
+// SetDeduplication serves requests that repeat the idempotency key of a
+// request answered successfully within the window from the stored reply
+// instead of running the handler again.
+func (r *RedisRequestResponseQueue[T, R]) SetDeduplication(store cache.Cache, window time.Duration) {
+	r.replies = newDeduplicator(store, window, r.name)
+}
+
+func (r *RedisRequestResponseQueue[T, R]) cancelKey(requestID string) string {
+	return r.queueName + "_cancel_" + requestID
 }
 
+// SendAndWait publishes a request and waits for its final reply. The request
+// gets the ID set on ctx with ContextWithRequestID, or a random one that is
+// not returned, so callers that want to Cancel it must set their own.
 func (r *RedisRequestResponseQueue[T, R]) SendAndWait(ctx context.Context, data T, timeout time.Duration) (R, error) {
 	var result R
 
-	requestID := uuid.New().String()
-	replyQueue := r.queueName + "_reply_" + requestID
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	replies, err := r.SendAndStream(timeoutCtx, data)
+	if err != nil {
+		return result, err
+	}
+
+	for reply := range replies {
+		if reply.Err != nil {
+			return result, reply.Err
+		}
+		if reply.Final {
+			return reply.Data, nil
+		}
+	}
+
+	if ctx.Err() == nil && timeoutCtx.Err() == context.DeadlineExceeded {
+		return result, fmt.Errorf("%w waiting for response", ErrTimeout)
+	}
+	return result, fmt.Errorf("failed to receive response: %v", timeoutCtx.Err())
+}
 
-	defer r.client.Del(ctx, replyQueue)
+// SendAndStream is like SendAndWait but returns every reply, partial ones
+// included. Cancelling ctx cancels the request on the worker.
+func (r *RedisRequestResponseQueue[T, R]) SendAndStream(ctx context.Context, data T) (<-chan Reply[R], error) {
+	requestID, ok := RequestIDFromContext(ctx)
+	if !ok {
+		requestID = uuid.New().String()
+	}
 
 	request := RequestMessage{
 		ID:      requestID,
-		ReplyTo: replyQueue,
+		ReplyTo: r.queueName + "_reply_" + requestID,
 		Data:    data,
 	}
 
-	if err := r.PublishMessage(request); err != nil {
-		return result, fmt.Errorf("failed to publish request: %v", err)
+	var source replySource = &dedicatedReplySource{client: r.client, key: request.ReplyTo}
+	if r.replyMode == MultiplexedReplies {
+		request.ReplyTo = r.dispatcher.key
+		source = r.dispatcher.register(r.ctx, requestID)
 	}
 
-	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+	var options *PublishOptions
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Deadline = &deadline
+		options = &PublishOptions{Deadline: deadline}
+	}
 
-	response, err := r.client.BRPop(timeoutCtx, timeout, replyQueue).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return result, fmt.Errorf("timeout waiting for response")
+	if err := r.Publish(ctx, request, options); err != nil {
+		source.close()
+		return nil, fmt.Errorf("failed to publish request: %v", err)
+	}
+
+	replies := make(chan Reply[R])
+	go r.receiveReplies(ctx, request.ID, source, replies)
+
+	return replies, nil
+}
+
+func (r *RedisRequestResponseQueue[T, R]) receiveReplies(ctx context.Context, requestID string, source replySource, replies chan<- Reply[R]) {
+	defer close(replies)
+	defer source.close()
+
+	for {
+		if ctx.Err() != nil {
+			r.cancelAbandoned(requestID)
+			return
+		}
+
+		response, err := source.next(ctx)
+		if err != nil {
+			if err == errNoMessage || ctx.Err() != nil {
+				continue
+			}
+			if !r.deliver(ctx, replies, Reply[R]{Err: fmt.Errorf("failed to receive response: %v", err)}) {
+				r.cancelAbandoned(requestID)
+			}
+			return
+		}
+
+		// A caller that stops reading and then cancels leaves us blocked
+		// delivering, which must stop the worker as well.
+		reply := r.decodeReply(response)
+		if !r.deliver(ctx, replies, reply) {
+			r.cancelAbandoned(requestID)
+			return
+		}
+		if reply.Final || reply.Err != nil {
+			return
 		}
-		return result, fmt.Errorf("failed to receive response: %v", err)
 	}
+}
 
-	var responseMsg ResponseMessage
-	if err := json.Unmarshal([]byte(response[1]), &responseMsg); err != nil {
-		return result, fmt.Errorf("failed to unmarshal response: %v", err)
+func (r *RedisRequestResponseQueue[T, R]) deliver(ctx context.Context, replies chan<- Reply[R], reply Reply[R]) bool {
+	select {
+	case replies <- reply:
+		return true
+	case <-ctx.Done():
+		return false
 	}
+}
+
+func (r *RedisRequestResponseQueue[T, R]) decodeReply(payload []byte) Reply[R] {
+	var reply Reply[R]
 
-	if responseMsg.Error != "" {
-		return result, fmt.Errorf("remote error: %s", responseMsg.Error)
+	var response responsePayload[R]
+	if err := r.format.codec.Unmarshal(payload, &response); err != nil {
+		reply.Err = fmt.Errorf("failed to unmarshal response: %v", err)
+		return reply
 	}
 
-	responseData, err := json.Marshal(responseMsg.Data)
-	if err != nil {
-		return result, fmt.Errorf("failed to marshal response data: %v", err)
+	if response.RemoteError != nil {
+		reply.Err = response.RemoteError
+		return reply
 	}
 
-	if err := json.Unmarshal(responseData, &result); err != nil {
-		return result, fmt.Errorf("failed to unmarshal response data: %v", err)
+	if response.Error != "" {
+		reply.Err = NewRemoteError(CodeInternal, response.Error)
+		return reply
 	}
 
-	return result, nil
+	reply.Data = response.Data
+	reply.Final = !response.Partial
+	return reply
 }
 
-func (r *RedisRequestResponseQueue[T, R]) ConsumeWithReply(handler func(T) (R, error)) error {
-	return r.ConsumeMessages(func(req RequestMessage) error {
-		var requestData T
-		
-		reqDataBytes, err := json.Marshal(req.Data)
-		if err != nil {
-			log.Printf("Error marshaling request data: %v", err)
-			return r.sendErrorResponse(req, fmt.Sprintf("failed to marshal request data: %v", err))
-		}
+// Cancel stops the request with the ID given to SendAndWait or SendAndStream
+// through ContextWithRequestID, whether or not a worker has picked it up yet.
+// The worker does not reply to a cancelled request, so a caller still waiting
+// on it only returns once its own context ends.
+func (r *RedisRequestResponseQueue[T, R]) Cancel(ctx context.Context, requestID string) error {
+	return r.client.Set(ctx, r.cancelKey(requestID), 1, cancelTTL).Err()
+}
+
+func (r *RedisRequestResponseQueue[T, R]) cancelAbandoned(requestID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := r.Cancel(ctx, requestID); err != nil {
+		log.Printf("Error cancelling request %s: %v", requestID, err)
+	}
+}
+
+func (r *RedisRequestResponseQueue[T, R]) isCancelled(ctx context.Context, requestID string) bool {
+	count, err := r.client.Exists(ctx, r.cancelKey(requestID)).Result()
+	return err == nil && count > 0
+}
+
+// watchCancellation cancels the returned context once the caller has given up
+// on the request.
+func (r *RedisRequestResponseQueue[T, R]) watchCancellation(ctx context.Context, requestID string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
 
-		if err := json.Unmarshal(reqDataBytes, &requestData); err != nil {
-			log.Printf("Error unmarshaling request data: %v", err)
-			return r.sendErrorResponse(req, fmt.Sprintf("failed to unmarshal request data: %v", err))
+	go func() {
+		ticker := time.NewTicker(r.cancelPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if r.isCancelled(ctx, requestID) {
+					cancel()
+					return
+				}
+			}
 		}
+	}()
+
+	return ctx, cancel
+}
+
+func (r *RedisRequestResponseQueue[T, R]) ConsumeWithReply(handler func(T) (R, error)) error {
+	err := r.ConsumeWithStream(r.ctx, func(_ context.Context, data T, _ func(R) error) (R, error) {
+		return handler(data)
+	})
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrShutdown) {
+		return nil
+	}
+	return err
+}
 
-		response, err := handler(requestData)
+func (r *RedisRequestResponseQueue[T, R]) ConsumeWithStream(ctx context.Context, handler StreamHandler[T, R]) error {
+	return r.consume(ctx, func(envelope *Envelope) (func(context.Context) error, error) {
+		// Replies are encoded like the request, which is what the caller decodes.
+		codec, err := codecFor(envelope.ContentType)
 		if err != nil {
-			log.Printf("Error processing request %s: %v", req.ID, err)
-			return r.sendErrorResponse(req, err.Error())
+			return nil, err
+		}
+
+		var payload requestPayload[T]
+		if err := r.format.decodePayload(envelope, &payload); err != nil {
+			var req RequestMessage
+			if r.format.decodePayload(envelope, &req) != nil || req.ReplyTo == "" {
+				return nil, err
+			}
+			return func(context.Context) error {
+				log.Printf("Error unmarshaling request data: %v", err)
+				remoteErr := NewRemoteError(CodeValidation, fmt.Sprintf("failed to unmarshal request data: %v", err))
+				if err := r.sendErrorResponse(req, codec, remoteErr); err != nil {
+					return err
+				}
+				return &settledError{remoteErr}
+			}, nil
 		}
 
-		return r.sendSuccessResponse(req, response)
+		req := RequestMessage{ID: payload.ID, ReplyTo: payload.ReplyTo, Deadline: payload.Deadline}
+		return func(ctx context.Context) error {
+			return r.handleRequest(ctx, req, payload.Data, codec, handler)
+		}, nil
 	})
 }
 
-func (r *RedisRequestResponseQueue[T, R]) sendErrorResponse(req RequestMessage, errorMsg string) error {
-	response := ResponseMessage{
-		ID:    req.ID,
-		Error: errorMsg,
+// handleRequest replies to req. Failures it replies with, and requests it
+// skips, are settled: the hooks see them but they are not redelivered.
+func (r *RedisRequestResponseQueue[T, R]) handleRequest(ctx context.Context, req RequestMessage, data T, codec Codec, handler StreamHandler[T, R]) error {
+	if req.Expired() {
+		log.Printf("Skipping expired request %s", req.ID)
+		return &settledError{ErrTimeout}
+	}
+
+	if r.isCancelled(ctx, req.ID) {
+		log.Printf("Skipping cancelled request %s", req.ID)
+		return &settledError{ErrCancelled}
+	}
+
+	metadata, _ := MetadataFromContext(ctx)
+
+	var cached R
+	if r.replies.seen(ctx, metadata.IdempotencyKey, &cached) {
+		log.Printf("Replying to request %s from the result stored for idempotency key %s", req.ID, metadata.IdempotencyKey)
+		return r.sendSuccessResponse(req, codec, cached)
+	}
+
+	handlerCtx, stop := r.watchCancellation(ctx, req.ID)
+	defer stop()
+
+	send := func(partial R) error {
+		if handlerCtx.Err() != nil {
+			return handlerCtx.Err()
+		}
+		return r.sendResponse(req, codec, ResponseMessage{
+			ID:      req.ID,
+			Data:    partial,
+			Partial: true,
+		})
+	}
+
+	response, err := handler(handlerCtx, data, send)
+	if handlerCtx.Err() != nil && ctx.Err() == nil {
+		log.Printf("Request %s was cancelled by the caller", req.ID)
+		return &settledError{ErrCancelled}
 	}
-	
-	responseData, err := json.Marshal(response)
 	if err != nil {
-		log.Printf("Error marshaling error response: %v", err)
-		return err
+		log.Printf("Error processing request %s: %v", req.ID, err)
+		if err := r.sendErrorResponse(req, codec, toRemoteError(err)); err != nil {
+			return err
+		}
+		return &settledError{err}
 	}
 
-	return r.client.LPush(r.ctx, req.ReplyTo, responseData).Err()
+	r.replies.record(ctx, metadata.IdempotencyKey, response)
+	return r.sendSuccessResponse(req, codec, response)
+}
+
+func (r *RedisRequestResponseQueue[T, R]) sendErrorResponse(req RequestMessage, codec Codec, remoteErr *RemoteError) error {
+	return r.sendResponse(req, codec, ResponseMessage{
+		ID:          req.ID,
+		Error:       remoteErr.Message,
+		RemoteError: remoteErr,
+	})
 }
 
-func (r *RedisRequestResponseQueue[T, R]) sendSuccessResponse(req RequestMessage, data R) error {
-	response := ResponseMessage{
+func (r *RedisRequestResponseQueue[T, R]) sendSuccessResponse(req RequestMessage, codec Codec, data R) error {
+	return r.sendResponse(req, codec, ResponseMessage{
 		ID:   req.ID,
 		Data: data,
-	}
-	
-	responseData, err := json.Marshal(response)
+	})
+}
+
+func (r *RedisRequestResponseQueue[T, R]) sendResponse(req RequestMessage, codec Codec, response ResponseMessage) error {
+	responseData, err := codec.Marshal(response)
 	if err != nil {
-		log.Printf("Error marshaling success response: %v", err)
+		log.Printf("Error marshaling response: %v", err)
 		return err
 	}
 
-	return r.client.LPush(r.ctx, req.ReplyTo, responseData).Err()
-}
\ No newline at end of file
+	_, err = r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(r.ctx, req.ReplyTo, responseData)
+		pipe.Expire(r.ctx, req.ReplyTo, r.replyTTL)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,503 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+
+	"mmm-osint/internal/pkg/cache"
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Redis Request Response Queue", func() {
+	type scrapeRequest struct {
+		URL string `json:"url"`
+	}
+
+	type scrapeProgress struct {
+		Step string `json:"step"`
+	}
+
+	var (
+		server *miniredis.Miniredis
+		client *queue.RedisRequestResponseQueue[scrapeRequest, scrapeProgress]
+		worker *queue.RedisRequestResponseQueue[scrapeRequest, scrapeProgress]
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		server = miniredis.RunT(GinkgoT())
+
+		var err error
+		client, err = queue.NewRedisRequestResponseQueue[scrapeRequest, scrapeProgress](server.Addr(), "", "scrape")
+		Expect(err).NotTo(HaveOccurred())
+		worker, err = queue.NewRedisRequestResponseQueue[scrapeRequest, scrapeProgress](server.Addr(), "", "scrape")
+		Expect(err).NotTo(HaveOccurred())
+		worker.SetCancelPollInterval(20 * time.Millisecond)
+
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		cancel()
+		_ = client.Close()
+		_ = worker.Close()
+	})
+
+	Describe("SendAndWait", func() {
+		It("should return the worker reply", func() {
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					return scrapeProgress{Step: "scraped " + req.URL}, nil
+				})
+			}()
+
+			result, err := client.SendAndWait(ctx, scrapeRequest{URL: "https://example.com"}, 3*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Step).To(Equal("scraped https://example.com"))
+		})
+
+		It("should return remote errors", func() {
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					return scrapeProgress{}, errors.New("unreachable host")
+				})
+			}()
+
+			_, err := client.SendAndWait(ctx, scrapeRequest{URL: "https://example.com"}, 3*time.Second)
+			Expect(err).To(MatchError("remote error: unreachable host"))
+			Expect(errors.Is(err, queue.ErrInternal)).To(BeTrue())
+		})
+
+		It("should rebuild typed remote errors", func() {
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					return scrapeProgress{}, queue.NewRemoteError(queue.CodeValidation, "url must be absolute").
+						WithDetails(map[string]any{"field": "url"})
+				})
+			}()
+
+			_, err := client.SendAndWait(ctx, scrapeRequest{URL: "example.com"}, 3*time.Second)
+			Expect(errors.Is(err, queue.ErrValidation)).To(BeTrue())
+			Expect(queue.IsRetryable(err)).To(BeFalse())
+
+			var remoteErr *queue.RemoteError
+			Expect(errors.As(err, &remoteErr)).To(BeTrue())
+			Expect(remoteErr.Message).To(Equal("url must be absolute"))
+			Expect(remoteErr.Details).To(HaveKeyWithValue("field", "url"))
+		})
+
+		It("should keep the code of wrapped sentinel errors", func() {
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					return scrapeProgress{}, fmt.Errorf("fetching %s: %w", req.URL, queue.ErrUnavailable)
+				})
+			}()
+
+			_, err := client.SendAndWait(ctx, scrapeRequest{URL: "https://example.com"}, 3*time.Second)
+			Expect(errors.Is(err, queue.ErrUnavailable)).To(BeTrue())
+			Expect(queue.IsRetryable(err)).To(BeTrue())
+			Expect(err).To(MatchError("remote error: fetching https://example.com: unavailable"))
+		})
+
+		It("should pick the same code for errors wrapping several sentinels", func() {
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					return scrapeProgress{}, errors.Join(queue.ErrUnavailable, queue.ErrValidation)
+				})
+			}()
+
+			for range 3 {
+				_, err := client.SendAndWait(ctx, scrapeRequest{URL: "https://example.com"}, 3*time.Second)
+				var remoteErr *queue.RemoteError
+				Expect(errors.As(err, &remoteErr)).To(BeTrue())
+				Expect(remoteErr.Code).To(Equal(queue.CodeValidation))
+			}
+		})
+
+		It("should ignore partial replies", func() {
+			go func() {
+				_ = worker.ConsumeWithStream(ctx, func(_ context.Context, req scrapeRequest, send func(scrapeProgress) error) (scrapeProgress, error) {
+					Expect(send(scrapeProgress{Step: "fetching"})).To(Succeed())
+					return scrapeProgress{Step: "done"}, nil
+				})
+			}()
+
+			result, err := client.SendAndWait(ctx, scrapeRequest{URL: "https://example.com"}, 3*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Step).To(Equal("done"))
+		})
+
+		It("should time out without a worker", func() {
+			_, err := client.SendAndWait(ctx, scrapeRequest{URL: "https://example.com"}, 100*time.Millisecond)
+			Expect(err).To(MatchError("timeout waiting for response"))
+			Expect(errors.Is(err, queue.ErrTimeout)).To(BeTrue())
+		})
+	})
+
+	Describe("SendAndStream", func() {
+		It("should deliver partial replies followed by the final one", func() {
+			go func() {
+				_ = worker.ConsumeWithStream(ctx, func(_ context.Context, req scrapeRequest, send func(scrapeProgress) error) (scrapeProgress, error) {
+					Expect(send(scrapeProgress{Step: "fetching"})).To(Succeed())
+					Expect(send(scrapeProgress{Step: "analyzing"})).To(Succeed())
+					return scrapeProgress{Step: "done"}, nil
+				})
+			}()
+
+			replies, err := client.SendAndStream(ctx, scrapeRequest{URL: "https://example.com"})
+			Expect(err).NotTo(HaveOccurred())
+
+			var received []queue.Reply[scrapeProgress]
+			Eventually(func() bool {
+				select {
+				case reply, ok := <-replies:
+					if !ok {
+						return true
+					}
+					received = append(received, reply)
+				default:
+				}
+				return false
+			}, 5*time.Second).Should(BeTrue())
+
+			Expect(received).To(Equal([]queue.Reply[scrapeProgress]{
+				{Data: scrapeProgress{Step: "fetching"}},
+				{Data: scrapeProgress{Step: "analyzing"}},
+				{Data: scrapeProgress{Step: "done"}, Final: true},
+			}))
+		})
+
+		It("should propagate caller cancellation to the worker", func() {
+			workerCancelled := make(chan struct{})
+			go func() {
+				_ = worker.ConsumeWithStream(ctx, func(handlerCtx context.Context, req scrapeRequest, send func(scrapeProgress) error) (scrapeProgress, error) {
+					Expect(send(scrapeProgress{Step: "fetching"})).To(Succeed())
+					<-handlerCtx.Done()
+					close(workerCancelled)
+					return scrapeProgress{}, handlerCtx.Err()
+				})
+			}()
+
+			callCtx, callCancel := context.WithCancel(ctx)
+			replies, err := client.SendAndStream(callCtx, scrapeRequest{URL: "https://example.com"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(replies, 3*time.Second).Should(Receive())
+			callCancel()
+
+			Eventually(workerCancelled, 3*time.Second).Should(BeClosed())
+			Eventually(replies).Should(BeClosed())
+		})
+
+		It("should propagate cancellation while a partial reply is pending", func() {
+			sent := make(chan struct{})
+			workerCancelled := make(chan struct{})
+			go func() {
+				_ = worker.ConsumeWithStream(ctx, func(handlerCtx context.Context, req scrapeRequest, send func(scrapeProgress) error) (scrapeProgress, error) {
+					Expect(send(scrapeProgress{Step: "fetching"})).To(Succeed())
+					close(sent)
+					<-handlerCtx.Done()
+					close(workerCancelled)
+					return scrapeProgress{}, handlerCtx.Err()
+				})
+			}()
+
+			callCtx, callCancel := context.WithCancel(queue.ContextWithRequestID(ctx, "request-pending"))
+			replies, err := client.SendAndStream(callCtx, scrapeRequest{URL: "https://example.com"})
+			Expect(err).NotTo(HaveOccurred())
+
+			// The reply was popped and waits for a reader that never comes.
+			Eventually(sent, 3*time.Second).Should(BeClosed())
+			Eventually(func() bool { return server.Exists("scrape_reply_request-pending") }, 3*time.Second).Should(BeFalse())
+			callCancel()
+
+			Eventually(workerCancelled, 3*time.Second).Should(BeClosed())
+			Eventually(replies).Should(BeClosed())
+		})
+	})
+
+	Describe("orphan cleanup", func() {
+		It("should skip requests whose caller has already timed out", func() {
+			_, err := client.SendAndWait(ctx, scrapeRequest{URL: "https://example.com"}, 50*time.Millisecond)
+			Expect(err).To(HaveOccurred())
+
+			handled := make(chan struct{}, 1)
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					handled <- struct{}{}
+					return scrapeProgress{}, nil
+				})
+			}()
+
+			Consistently(handled, 300*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("should expire reply keys nobody reads", func() {
+			worker.SetReplyTTL(time.Minute)
+			Expect(client.Publish(ctx, queue.RequestMessage{
+				ID:      "orphan",
+				ReplyTo: "scrape_reply_orphan",
+				Data:    scrapeRequest{URL: "https://example.com"},
+			}, nil)).To(Succeed())
+
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					return scrapeProgress{Step: "done"}, nil
+				})
+			}()
+
+			Eventually(func() bool { return server.Exists("scrape_reply_orphan") }, 3*time.Second).Should(BeTrue())
+			Expect(server.TTL("scrape_reply_orphan")).To(Equal(time.Minute))
+		})
+
+		It("should let callers cancel a request explicitly", func() {
+			started := make(chan struct{})
+			workerCancelled := make(chan struct{})
+			go func() {
+				_ = worker.ConsumeWithStream(ctx, func(handlerCtx context.Context, req scrapeRequest, send func(scrapeProgress) error) (scrapeProgress, error) {
+					close(started)
+					<-handlerCtx.Done()
+					close(workerCancelled)
+					return scrapeProgress{}, handlerCtx.Err()
+				})
+			}()
+
+			callCtx := queue.ContextWithRequestID(ctx, "request-1")
+			_, err := client.SendAndStream(callCtx, scrapeRequest{URL: "https://example.com"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(started, 3*time.Second).Should(BeClosed())
+			Expect(client.Cancel(ctx, "request-1")).To(Succeed())
+			Eventually(workerCancelled, 3*time.Second).Should(BeClosed())
+		})
+
+		It("should skip requests cancelled before a worker picked them up", func() {
+			callCtx := queue.ContextWithRequestID(ctx, "request-2")
+			_, err := client.SendAndStream(callCtx, scrapeRequest{URL: "https://example.com"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Cancel(ctx, "request-2")).To(Succeed())
+
+			handled := make(chan struct{}, 1)
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					handled <- struct{}{}
+					return scrapeProgress{}, nil
+				})
+			}()
+
+			Consistently(handled, 300*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+
+	Describe("hooks", func() {
+		var hooks *recordingHooks
+
+		BeforeEach(func() {
+			hooks = &recordingHooks{}
+			worker.AddHooks(hooks)
+			worker.SetRetryPolicy(&queue.RetryPolicy{MaxAttempts: 3, DeadLetter: true})
+		})
+
+		handledErrors := func() []error {
+			hooks.mutex.Lock()
+			defer hooks.mutex.Unlock()
+
+			var errs []error
+			for _, event := range hooks.events {
+				if event.kind == "handled" {
+					errs = append(errs, event.err)
+				}
+			}
+			return errs
+		}
+
+		It("should report handler failures it replied with without retrying them", func() {
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					return scrapeProgress{}, errors.New("unreachable host")
+				})
+			}()
+
+			_, err := client.SendAndWait(ctx, scrapeRequest{URL: "https://example.com"}, 3*time.Second)
+			Expect(err).To(MatchError("remote error: unreachable host"))
+
+			Eventually(handledErrors).Should(ConsistOf(MatchError("unreachable host")))
+			Consistently(hooks.kinds, 100*time.Millisecond).ShouldNot(ContainElements("retry", "dead_letter"))
+		})
+
+		It("should not report cancelled requests as handled successfully", func() {
+			callCtx := queue.ContextWithRequestID(ctx, "request-3")
+			_, err := client.SendAndStream(callCtx, scrapeRequest{URL: "https://example.com"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Cancel(ctx, "request-3")).To(Succeed())
+
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					return scrapeProgress{}, nil
+				})
+			}()
+
+			Eventually(handledErrors, 3*time.Second).Should(ConsistOf(MatchError(queue.ErrCancelled)))
+		})
+	})
+
+	Describe("codecs", func() {
+		It("should exchange requests and replies in the caller's codec", func() {
+			client.SetCodec(queue.MessagePackCodec)
+
+			go func() {
+				_ = worker.ConsumeWithStream(ctx, func(_ context.Context, req scrapeRequest, send func(scrapeProgress) error) (scrapeProgress, error) {
+					Expect(send(scrapeProgress{Step: "fetching " + req.URL})).To(Succeed())
+					return scrapeProgress{Step: "done"}, nil
+				})
+			}()
+
+			replies, err := client.SendAndStream(ctx, scrapeRequest{URL: "https://example.com"})
+			Expect(err).NotTo(HaveOccurred())
+
+			var reply queue.Reply[scrapeProgress]
+			Eventually(replies, 3*time.Second).Should(Receive(&reply))
+			Expect(reply.Data.Step).To(Equal("fetching https://example.com"))
+			Eventually(replies, 3*time.Second).Should(Receive(&reply))
+			Expect(reply.Final).To(BeTrue())
+			Expect(reply.Data.Step).To(Equal("done"))
+		})
+
+		It("should answer requests whose data does not match with a validation error", func() {
+			legacy, err := queue.NewRedisRequestResponseQueue[string, scrapeProgress](server.Addr(), "", "scrape")
+			Expect(err).NotTo(HaveOccurred())
+			defer legacy.Close()
+
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					return scrapeProgress{Step: "unexpected"}, nil
+				})
+			}()
+
+			_, err = legacy.SendAndWait(ctx, "not an object", 3*time.Second)
+			Expect(errors.Is(err, queue.ErrValidation)).To(BeTrue())
+		})
+	})
+
+	Describe("idempotency keys", func() {
+		It("should answer repeated requests from the stored reply", func() {
+			store, err := cache.NewLRUCache(10)
+			Expect(err).NotTo(HaveOccurred())
+			worker.SetDeduplication(store, time.Minute)
+
+			var calls atomic.Int32
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					return scrapeProgress{Step: fmt.Sprintf("scrape %d", calls.Add(1))}, nil
+				})
+			}()
+
+			keyed := queue.ContextWithIdempotencyKey(ctx, "scrape:https://example.com")
+			first, err := client.SendAndWait(keyed, scrapeRequest{URL: "https://example.com"}, 3*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			second, err := client.SendAndWait(keyed, scrapeRequest{URL: "https://example.com"}, 3*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(first.Step).To(Equal("scrape 1"))
+			Expect(second).To(Equal(first))
+			Expect(calls.Load()).To(Equal(int32(1)))
+
+			unkeyed, err := client.SendAndWait(ctx, scrapeRequest{URL: "https://example.com"}, 3*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unkeyed.Step).To(Equal("scrape 2"))
+		})
+	})
+
+	Describe("multiplexed replies", func() {
+		BeforeEach(func() {
+			client.SetReplyMode(queue.MultiplexedReplies)
+		})
+
+		It("should address requests to the shared reply key", func() {
+			_, err := client.SendAndStream(ctx, scrapeRequest{URL: "https://example.com"})
+			Expect(err).NotTo(HaveOccurred())
+
+			items, err := server.List("scrape")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(items).To(HaveLen(1))
+
+			var envelope queue.Envelope
+			Expect(json.Unmarshal([]byte(items[0]), &envelope)).To(Succeed())
+			var request queue.RequestMessage
+			Expect(json.Unmarshal(envelope.Payload, &request)).To(Succeed())
+			Expect(request.ReplyTo).To(HavePrefix("scrape_replies_"))
+		})
+
+		It("should route concurrent replies to their callers", func() {
+			go func() {
+				_ = worker.ConsumeWithReply(func(req scrapeRequest) (scrapeProgress, error) {
+					return scrapeProgress{Step: req.URL}, nil
+				})
+			}()
+
+			var wg sync.WaitGroup
+			for i := range 20 {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					url := fmt.Sprintf("https://example.com/%d", i)
+					result, err := client.SendAndWait(ctx, scrapeRequest{URL: url}, 5*time.Second)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(result.Step).To(Equal(url))
+				}()
+			}
+			wg.Wait()
+		})
+
+		It("should stream partial replies", func() {
+			go func() {
+				_ = worker.ConsumeWithStream(ctx, func(_ context.Context, req scrapeRequest, send func(scrapeProgress) error) (scrapeProgress, error) {
+					Expect(send(scrapeProgress{Step: "fetching"})).To(Succeed())
+					return scrapeProgress{Step: "done"}, nil
+				})
+			}()
+
+			replies, err := client.SendAndStream(ctx, scrapeRequest{URL: "https://example.com"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(replies, 3*time.Second).Should(Receive(Equal(queue.Reply[scrapeProgress]{Data: scrapeProgress{Step: "fetching"}})))
+			Eventually(replies, 3*time.Second).Should(Receive(Equal(queue.Reply[scrapeProgress]{Data: scrapeProgress{Step: "done"}, Final: true})))
+			Eventually(replies).Should(BeClosed())
+		})
+
+		It("should back off while Redis keeps failing", func() {
+			pops := &failingPops{}
+			redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+			DeferCleanup(redisClient.Close)
+			redisClient.AddHook(pops)
+
+			caller := queue.NewRedisRequestResponseQueueWithClient[scrapeRequest, scrapeProgress](redisClient, "scrape")
+			DeferCleanup(caller.Close)
+			caller.SetReplyMode(queue.MultiplexedReplies)
+
+			_, err := caller.SendAndStream(ctx, scrapeRequest{URL: "https://example.com"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(pops.count.Load).Should(BeNumerically(">=", 2))
+			Consistently(pops.count.Load, 500*time.Millisecond).Should(BeNumerically("<", 10))
+		})
+
+		It("should time out like dedicated replies", func() {
+			_, err := client.SendAndWait(ctx, scrapeRequest{URL: "https://example.com"}, 100*time.Millisecond)
+			Expect(err).To(MatchError("timeout waiting for response"))
+		})
+	})
+})
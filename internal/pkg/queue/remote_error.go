@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"context"
+	"errors"
+)
+
+type ErrorCode string
+
+const (
+	CodeInternal    ErrorCode = "internal"
+	CodeValidation  ErrorCode = "validation"
+	CodeNotFound    ErrorCode = "not_found"
+	CodeTimeout     ErrorCode = "timeout"
+	CodeUnavailable ErrorCode = "unavailable"
+	CodeCancelled   ErrorCode = "cancelled"
+)
+
+var (
+	ErrInternal    = errors.New("internal error")
+	ErrValidation  = errors.New("validation failed")
+	ErrNotFound    = errors.New("not found")
+	ErrTimeout     = errors.New("timeout")
+	ErrUnavailable = errors.New("unavailable")
+	ErrCancelled   = errors.New("cancelled")
+)
+
+// codeErrors is ordered so that an error wrapping several sentinels always
+// gets the code of the first one listed.
+var codeErrors = []struct {
+	code     ErrorCode
+	sentinel error
+}{
+	{CodeValidation, ErrValidation},
+	{CodeNotFound, ErrNotFound},
+	{CodeTimeout, ErrTimeout},
+	{CodeUnavailable, ErrUnavailable},
+	{CodeCancelled, ErrCancelled},
+	{CodeInternal, ErrInternal},
+}
+
+type RemoteError struct {
+	Code      ErrorCode      `json:"code"`
+	Message   string         `json:"message"`
+	Retryable bool           `json:"retryable"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+func NewRemoteError(code ErrorCode, message string) *RemoteError {
+	return &RemoteError{
+		Code:      code,
+		Message:   message,
+		Retryable: code == CodeTimeout || code == CodeUnavailable,
+	}
+}
+
+func (e *RemoteError) WithDetails(details map[string]any) *RemoteError {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+func (e *RemoteError) Error() string {
+	return "remote error: " + e.Message
+}
+
+func (e *RemoteError) Unwrap() error {
+	for _, entry := range codeErrors {
+		if entry.code == e.Code {
+			return entry.sentinel
+		}
+	}
+	return ErrInternal
+}
+
+func IsRetryable(err error) bool {
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		return remoteErr.Retryable
+	}
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable)
+}
+
+// toRemoteError converts a handler error into its wire form, keeping the code
+// of typed errors and of the sentinel errors they wrap.
+func toRemoteError(err error) *RemoteError {
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		copied := *remoteErr
+		return &copied
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return NewRemoteError(CodeTimeout, err.Error())
+	case errors.Is(err, context.Canceled):
+		return NewRemoteError(CodeCancelled, err.Error())
+	}
+
+	for _, entry := range codeErrors {
+		if errors.Is(err, entry.sentinel) {
+			return NewRemoteError(entry.code, err.Error())
+		}
+	}
+
+	return NewRemoteError(CodeInternal, err.Error())
+}
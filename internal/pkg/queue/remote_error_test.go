@@ -0,0 +1,60 @@
+package queue_test
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("RemoteError", func() {
+	It("should describe the remote failure", func() {
+		err := queue.NewRemoteError(queue.CodeValidation, "url is required")
+		Expect(err.Error()).To(Equal("remote error: url is required"))
+	})
+
+	It("should match the sentinel error of its code", func() {
+		err := fmt.Errorf("scrape failed: %w", queue.NewRemoteError(queue.CodeNotFound, "no such page"))
+
+		Expect(errors.Is(err, queue.ErrNotFound)).To(BeTrue())
+		Expect(errors.Is(err, queue.ErrValidation)).To(BeFalse())
+
+		var remoteErr *queue.RemoteError
+		Expect(errors.As(err, &remoteErr)).To(BeTrue())
+		Expect(remoteErr.Code).To(Equal(queue.CodeNotFound))
+	})
+
+	It("should fall back to the internal sentinel for unknown codes", func() {
+		err := &queue.RemoteError{Code: "quota_exceeded", Message: "too many requests"}
+		Expect(errors.Is(err, queue.ErrInternal)).To(BeTrue())
+	})
+
+	It("should mark transient codes as retryable", func() {
+		Expect(queue.NewRemoteError(queue.CodeTimeout, "slow upstream").Retryable).To(BeTrue())
+		Expect(queue.NewRemoteError(queue.CodeUnavailable, "upstream down").Retryable).To(BeTrue())
+		Expect(queue.NewRemoteError(queue.CodeValidation, "bad input").Retryable).To(BeFalse())
+	})
+
+	It("should attach details without modifying the original", func() {
+		original := queue.NewRemoteError(queue.CodeValidation, "bad input")
+		detailed := original.WithDetails(map[string]any{"field": "url"})
+
+		Expect(detailed.Details).To(HaveKeyWithValue("field", "url"))
+		Expect(original.Details).To(BeNil())
+	})
+
+	Describe("IsRetryable", func() {
+		It("should use the retryable flag of remote errors", func() {
+			err := &queue.RemoteError{Code: queue.CodeInternal, Message: "flaky", Retryable: true}
+			Expect(queue.IsRetryable(fmt.Errorf("wrapped: %w", err))).To(BeTrue())
+		})
+
+		It("should recognise transient sentinel errors", func() {
+			Expect(queue.IsRetryable(fmt.Errorf("wrapped: %w", queue.ErrUnavailable))).To(BeTrue())
+			Expect(queue.IsRetryable(errors.New("boom"))).To(BeFalse())
+		})
+	})
+})
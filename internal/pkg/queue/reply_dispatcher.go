@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type ReplyMode int
+
+const (
+	DedicatedReplies ReplyMode = iota
+	MultiplexedReplies
+)
+
+type replySource interface {
+	next(ctx context.Context) ([]byte, error)
+	close()
+}
+
+type dedicatedReplySource struct {
+	client redis.UniversalClient
+	key    string
+}
+
+func (s *dedicatedReplySource) next(ctx context.Context) ([]byte, error) {
+	result, err := s.client.BRPop(ctx, 1*time.Second, s.key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, errNoMessage
+		}
+		return nil, err
+	}
+	return []byte(result[1]), nil
+}
+
+func (s *dedicatedReplySource) close() {
+	s.client.Del(context.Background(), s.key)
+}
+
+type replyWaiter struct {
+	dispatcher *replyDispatcher
+	requestID  string
+	mutex      sync.Mutex
+	pending    [][]byte
+	notify     chan struct{}
+}
+
+func (w *replyWaiter) push(payload []byte) {
+	w.mutex.Lock()
+	w.pending = append(w.pending, payload)
+	w.mutex.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *replyWaiter) next(ctx context.Context) ([]byte, error) {
+	for {
+		w.mutex.Lock()
+		if len(w.pending) > 0 {
+			payload := w.pending[0]
+			w.pending = w.pending[1:]
+			w.mutex.Unlock()
+			return payload, nil
+		}
+		w.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-w.notify:
+		}
+	}
+}
+
+func (w *replyWaiter) close() {
+	w.dispatcher.unregister(w.requestID)
+}
+
+// replyDispatcher reads every reply addressed to this client instance from a
+// single list and routes it to the caller waiting on the matching request.
+// It starts with the first request and runs until the context of that first
+// registration ends, which is the queue's own, retrying Redis errors in the
+// meantime. It is not restarted, so a closed queue no longer gets replies.
+type replyDispatcher struct {
+	client  redis.UniversalClient
+	key     string
+	decode  func(data []byte, v any) error
+	once    sync.Once
+	mutex   sync.Mutex
+	waiters map[string]*replyWaiter
+}
+
+func newReplyDispatcher(client redis.UniversalClient, key string, decode func(data []byte, v any) error) *replyDispatcher {
+	return &replyDispatcher{
+		client:  client,
+		key:     key,
+		decode:  decode,
+		waiters: make(map[string]*replyWaiter),
+	}
+}
+
+func (d *replyDispatcher) register(ctx context.Context, requestID string) *replyWaiter {
+	d.once.Do(func() {
+		go d.run(ctx)
+	})
+
+	waiter := &replyWaiter{
+		dispatcher: d,
+		requestID:  requestID,
+		notify:     make(chan struct{}, 1),
+	}
+
+	d.mutex.Lock()
+	d.waiters[requestID] = waiter
+	d.mutex.Unlock()
+
+	return waiter
+}
+
+func (d *replyDispatcher) unregister(requestID string) {
+	d.mutex.Lock()
+	delete(d.waiters, requestID)
+	d.mutex.Unlock()
+}
+
+func (d *replyDispatcher) run(ctx context.Context) {
+	defer d.client.Del(context.Background(), d.key)
+
+	var backoff errorBackoff
+	for ctx.Err() == nil {
+		result, err := d.client.BRPop(ctx, 1*time.Second, d.key).Result()
+		if err != nil {
+			if err == redis.Nil {
+				backoff.reset()
+			} else if ctx.Err() == nil {
+				log.Printf("Error receiving replies (%s): %v", d.key, err)
+				backoff.wait(ctx)
+			}
+			continue
+		}
+		backoff.reset()
+
+		d.dispatch([]byte(result[1]))
+	}
+}
+
+func (d *replyDispatcher) dispatch(payload []byte) {
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := d.decode(payload, &response); err != nil {
+		log.Printf("Error unmarshaling reply (%s): %v", d.key, err)
+		return
+	}
+
+	d.mutex.Lock()
+	waiter, ok := d.waiters[response.ID]
+	d.mutex.Unlock()
+
+	if !ok {
+		log.Printf("Dropping reply for unknown request %s", response.ID)
+		return
+	}
+
+	waiter.push(payload)
+}
@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	ExitOK              = 0
+	ExitFailure         = 1
+	ExitShutdownTimeout = 2
+)
+
+type Drainable interface {
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+type runnerWorker struct {
+	queue   Drainable
+	consume func(ctx context.Context) error
+}
+
+// Runner runs queue consumers until the process is asked to stop, then
+// drains them so rolling deploys do not drop or duplicate work.
+type Runner struct {
+	workers         []runnerWorker
+	signals         []os.Signal
+	shutdownTimeout time.Duration
+}
+
+func NewRunner() *Runner {
+	return &Runner{
+		signals:         []os.Signal{syscall.SIGTERM, os.Interrupt},
+		shutdownTimeout: 30 * time.Second,
+	}
+}
+
+func (r *Runner) SetShutdownTimeout(timeout time.Duration) {
+	r.shutdownTimeout = timeout
+}
+
+func (r *Runner) SetSignals(signals ...os.Signal) {
+	r.signals = signals
+}
+
+// Add registers a consumer. consume is typically a closure over the queue's
+// Consume or ConsumeWithStream and should return once the queue shuts down.
+func (r *Runner) Add(queue Drainable, consume func(ctx context.Context) error) {
+	r.workers = append(r.workers, runnerWorker{queue: queue, consume: consume})
+}
+
+// Run starts every consumer and blocks until a signal arrives, ctx is done
+// or a consumer fails. It returns the process exit code: ExitOK after a clean
+// drain, ExitShutdownTimeout if in-flight messages had to be requeued and
+// ExitFailure if a consumer failed.
+func (r *Runner) Run(ctx context.Context) int {
+	stopCtx, stop := signal.NotifyContext(ctx, r.signals...)
+	defer stop()
+
+	// Consumers get a context that outlives the signal so their handlers
+	// can finish while the queues drain.
+	consumeCtx, cancelConsumers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelConsumers()
+
+	failed := make(chan struct{})
+	var failOnce sync.Once
+	exitCode := ExitOK
+
+	var consumers sync.WaitGroup
+	for _, worker := range r.workers {
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+
+			err := worker.consume(consumeCtx)
+			if err == nil || errors.Is(err, ErrShutdown) || errors.Is(err, context.Canceled) {
+				return
+			}
+			log.Printf("Queue consumer stopped: %v", err)
+			failOnce.Do(func() { close(failed) })
+		}()
+	}
+
+	select {
+	case <-stopCtx.Done():
+		log.Printf("Shutting down queue consumers")
+	case <-failed:
+		exitCode = ExitFailure
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), r.shutdownTimeout)
+	defer cancel()
+
+	queues := r.queues()
+
+	var shutdowns sync.WaitGroup
+	var mutex sync.Mutex
+	for _, queue := range queues {
+		shutdowns.Add(1)
+		go func() {
+			defer shutdowns.Done()
+
+			if err := queue.Shutdown(shutdownCtx); err != nil {
+				log.Printf("Error shutting down queue: %v", err)
+				mutex.Lock()
+				if exitCode == ExitOK {
+					exitCode = ExitShutdownTimeout
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	shutdowns.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		consumers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		cancelConsumers()
+		log.Printf("Queue consumers did not stop before the shutdown timeout")
+	}
+
+	for _, queue := range queues {
+		if err := queue.Close(); err != nil {
+			log.Printf("Error closing queue: %v", err)
+		}
+	}
+
+	return exitCode
+}
+
+func (r *Runner) queues() []Drainable {
+	seen := make(map[Drainable]bool)
+	var queues []Drainable
+	for _, worker := range r.workers {
+		if !seen[worker.queue] {
+			seen[worker.queue] = true
+			queues = append(queues, worker.queue)
+		}
+	}
+	return queues
+}
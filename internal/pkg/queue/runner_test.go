@@ -0,0 +1,104 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Runner", func() {
+	var (
+		q      *queue.MemoryQueue[string]
+		runner *queue.Runner
+	)
+
+	BeforeEach(func() {
+		q = queue.NewMemoryQueue[string]("runner")
+		runner = queue.NewRunner()
+		runner.SetSignals(syscall.SIGUSR2)
+		runner.SetShutdownTimeout(time.Second)
+	})
+
+	run := func(ctx context.Context) chan int {
+		exitCode := make(chan int, 1)
+		go func() {
+			exitCode <- runner.Run(ctx)
+		}()
+		return exitCode
+	}
+
+	It("should drain consumers and exit cleanly on a signal", func() {
+		started := make(chan struct{})
+		finished := make(chan struct{})
+		Expect(q.Publish(context.Background(), "job", nil)).To(Succeed())
+
+		runner.Add(q, func(ctx context.Context) error {
+			return q.Consume(ctx, func(handlerCtx context.Context, _ string) error {
+				close(started)
+				time.Sleep(50 * time.Millisecond)
+				Expect(handlerCtx.Err()).NotTo(HaveOccurred())
+				close(finished)
+				return nil
+			})
+		})
+
+		exitCode := run(context.Background())
+		Eventually(started).Should(BeClosed())
+		Expect(syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)).To(Succeed())
+
+		Eventually(exitCode).Should(Receive(Equal(queue.ExitOK)))
+		Expect(finished).To(BeClosed())
+	})
+
+	It("should stop when its context is cancelled", func() {
+		runner.Add(q, func(ctx context.Context) error {
+			return q.Consume(ctx, func(context.Context, string) error { return nil })
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		exitCode := run(ctx)
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+
+		Eventually(exitCode).Should(Receive(Equal(queue.ExitOK)))
+	})
+
+	It("should report handlers that outlive the shutdown timeout", func() {
+		runner.SetShutdownTimeout(50 * time.Millisecond)
+		started := make(chan struct{})
+		Expect(q.Publish(context.Background(), "job", nil)).To(Succeed())
+
+		runner.Add(q, func(ctx context.Context) error {
+			return q.Consume(ctx, func(handlerCtx context.Context, _ string) error {
+				close(started)
+				<-handlerCtx.Done()
+				return handlerCtx.Err()
+			})
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		exitCode := run(ctx)
+		Eventually(started).Should(BeClosed())
+		cancel()
+
+		Eventually(exitCode).Should(Receive(Equal(queue.ExitShutdownTimeout)))
+	})
+
+	It("should stop everything when a consumer fails", func() {
+		other := queue.NewMemoryQueue[string]("other")
+		runner.Add(q, func(ctx context.Context) error {
+			return q.Consume(ctx, func(context.Context, string) error { return nil })
+		})
+		runner.Add(other, func(context.Context) error {
+			return errors.New("lost connection")
+		})
+
+		Eventually(run(context.Background())).Should(Receive(Equal(queue.ExitFailure)))
+	})
+})
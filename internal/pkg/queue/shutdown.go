@@ -0,0 +1,151 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// closeTimeout bounds how long Close waits for consumers to return.
+const closeTimeout = 5 * time.Second
+
+var (
+	ErrShutdown        = errors.New("queue is shutting down")
+	ErrShutdownTimeout = errors.New("timed out waiting for in-flight messages")
+)
+
+// delivery is a message a handler is working on. It is abandoned when a
+// shutdown gives up on it and pushes it back to the queue, after which the
+// outcome of the handler is ignored.
+type delivery struct {
+	envelope  *Envelope
+	payload   []byte
+	abandoned atomic.Bool
+}
+
+// lifecycle tracks the consumers of a queue so Shutdown can stop them from
+// fetching and wait for the messages they are handling.
+type lifecycle struct {
+	draining context.Context
+	drain    context.CancelFunc
+	mutex    sync.Mutex
+	running  int
+	idle     chan struct{}
+	idleOnce sync.Once
+	active   map[*delivery]struct{}
+}
+
+func newLifecycle() *lifecycle {
+	draining, drain := context.WithCancel(context.Background())
+
+	return &lifecycle{
+		draining: draining,
+		drain:    drain,
+		idle:     make(chan struct{}),
+		active:   make(map[*delivery]struct{}),
+	}
+}
+
+func (l *lifecycle) enter() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.draining.Err() != nil {
+		return false
+	}
+	l.running++
+	return true
+}
+
+func (l *lifecycle) exit() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.running--
+	if l.running == 0 && l.draining.Err() != nil {
+		l.idleOnce.Do(func() { close(l.idle) })
+	}
+}
+
+func (l *lifecycle) begin(envelope *Envelope, payload []byte) *delivery {
+	d := &delivery{envelope: envelope, payload: payload}
+
+	l.mutex.Lock()
+	l.active[d] = struct{}{}
+	l.mutex.Unlock()
+
+	return d
+}
+
+func (l *lifecycle) finish(d *delivery) {
+	l.mutex.Lock()
+	delete(l.active, d)
+	l.mutex.Unlock()
+}
+
+func (l *lifecycle) stop() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.drain()
+	if l.running == 0 {
+		l.idleOnce.Do(func() { close(l.idle) })
+	}
+}
+
+func (l *lifecycle) abandon() []*delivery {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	abandoned := make([]*delivery, 0, len(l.active))
+	for d := range l.active {
+		if d.abandoned.CompareAndSwap(false, true) {
+			abandoned = append(abandoned, d)
+		}
+	}
+	return abandoned
+}
+
+// Shutdown stops every consumer of the queue from fetching new messages and
+// waits for the messages they are handling. If ctx is done first, those
+// messages are pushed back to the queue for another consumer and their
+// handlers are cancelled. Consumers return ErrShutdown once they stopped.
+func (c *core[T]) Shutdown(ctx context.Context) error {
+	c.life.stop()
+
+	select {
+	case <-c.life.idle:
+		return nil
+	case <-ctx.Done():
+	}
+
+	abandoned := c.life.abandon()
+	for _, d := range abandoned {
+		if err := c.transport.push(context.Background(), d.envelope.Priority, d.payload); err != nil {
+			log.Printf("Error requeueing message %s for queue (%s): %v", d.envelope.ID, c.name, err)
+		}
+	}
+	c.cancel()
+
+	return fmt.Errorf("%w: requeued %d messages", ErrShutdownTimeout, len(abandoned))
+}
+
+// stop cancels every consumer and waits for them to return, so that the
+// messages they fetched ahead are pushed back before the client is closed.
+// Unlike Shutdown it does not wait for handlers to finish their work.
+func (c *core[T]) stop() {
+	c.life.stop()
+	c.cancel()
+
+	timer := time.NewTimer(closeTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.life.idle:
+	case <-timer.C:
+		log.Printf("Timed out waiting for consumers of queue (%s) to stop", c.name)
+	}
+}
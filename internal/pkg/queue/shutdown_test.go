@@ -0,0 +1,130 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Graceful shutdown", func() {
+	var (
+		q   *queue.MemoryQueue[string]
+		ctx context.Context
+	)
+
+	BeforeEach(func() {
+		q = queue.NewMemoryQueue[string]("shutdown")
+		ctx = context.Background()
+	})
+
+	AfterEach(func() {
+		Expect(q.Close()).To(Succeed())
+	})
+
+	It("should let in-flight handlers finish before returning", func() {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		handlerErr := make(chan error, 1)
+		done := make(chan error, 1)
+
+		Expect(q.Publish(ctx, "slow", nil)).To(Succeed())
+		go func() {
+			done <- q.Consume(ctx, func(handlerCtx context.Context, _ string) error {
+				close(started)
+				<-release
+				handlerErr <- handlerCtx.Err()
+				return nil
+			})
+		}()
+		Eventually(started).Should(BeClosed())
+
+		shutdown := make(chan error, 1)
+		go func() {
+			shutdown <- q.Shutdown(ctx)
+		}()
+		Consistently(shutdown, 50*time.Millisecond).ShouldNot(Receive())
+
+		close(release)
+		Eventually(shutdown).Should(Receive(BeNil()))
+		Expect(handlerErr).To(Receive(BeNil()))
+		Expect(done).To(Receive(MatchError(queue.ErrShutdown)))
+	})
+
+	It("should stop idle consumers from fetching", func() {
+		done := make(chan error, 1)
+		go func() {
+			done <- q.ConsumeMessages(func(string) error { return nil })
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		Expect(q.Shutdown(ctx)).To(Succeed())
+		Eventually(done).Should(Receive(BeNil()))
+
+		Expect(q.Consume(ctx, func(context.Context, string) error { return nil })).To(MatchError(queue.ErrShutdown))
+	})
+
+	It("should treat wrapped cancellation as a clean stop in ConsumeMessages", func() {
+		done := make(chan error, 1)
+		go func() {
+			done <- q.ConsumeMessages(func(string) error { return nil })
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		Expect(q.Close()).To(Succeed())
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	Describe("with a deadline", func() {
+		var (
+			server *miniredis.Miniredis
+			rq     *queue.RedisQueue[string]
+		)
+
+		BeforeEach(func() {
+			server = miniredis.RunT(GinkgoT())
+
+			var err error
+			rq, err = queue.NewRedisQueue[string](server.Addr(), "", "shutdown")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			_ = rq.Close()
+		})
+
+		It("should requeue unfinished messages and cancel their handlers", func() {
+			started := make(chan struct{})
+			cancelled := make(chan struct{})
+
+			Expect(rq.Publish(ctx, "stuck", nil)).To(Succeed())
+			go func() {
+				_ = rq.Consume(ctx, func(handlerCtx context.Context, _ string) error {
+					close(started)
+					<-handlerCtx.Done()
+					close(cancelled)
+					return handlerCtx.Err()
+				})
+			}()
+			Eventually(started, 3*time.Second).Should(BeClosed())
+
+			shutdownCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+			err := rq.Shutdown(shutdownCtx)
+			Expect(errors.Is(err, queue.ErrShutdownTimeout)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("requeued 1 messages")))
+
+			Eventually(cancelled).Should(BeClosed())
+
+			items, err := server.List("shutdown")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(items).To(HaveLen(1))
+			Expect(server.Exists("shutdown_dead")).To(BeFalse())
+		})
+	})
+})
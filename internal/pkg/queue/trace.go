@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+)
+
+// TraceContext is the W3C trace context of the current span.
+type TraceContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+	State   string
+}
+
+func NewTraceContext() TraceContext {
+	return TraceContext{
+		TraceID: randomHex(16),
+		SpanID:  randomHex(8),
+		Sampled: true,
+	}
+}
+
+// Child returns a new span in the same trace.
+func (t TraceContext) Child() TraceContext {
+	t.SpanID = randomHex(8)
+	return t
+}
+
+func (t TraceContext) TraceParent() string {
+	flags := "00"
+	if t.Sampled {
+		flags = "01"
+	}
+	return "00-" + t.TraceID + "-" + t.SpanID + "-" + flags
+}
+
+func ParseTraceParent(header string) (TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, fmt.Errorf("invalid traceparent: %s", header)
+	}
+
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isHex(version, 1) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return TraceContext{}, fmt.Errorf("unsupported traceparent version: %s", version)
+	}
+	if !isHex(traceID, 16) || traceID == strings.Repeat("0", 32) {
+		return TraceContext{}, fmt.Errorf("invalid trace id: %s", traceID)
+	}
+	if !isHex(spanID, 8) || spanID == strings.Repeat("0", 16) {
+		return TraceContext{}, fmt.Errorf("invalid span id: %s", spanID)
+	}
+	if !isHex(flags, 1) {
+		return TraceContext{}, fmt.Errorf("invalid trace flags: %s", flags)
+	}
+
+	flagBits, _ := hex.DecodeString(flags)
+	return TraceContext{
+		TraceID: traceID,
+		SpanID:  spanID,
+		Sampled: flagBits[0]&0x01 == 0x01,
+	}, nil
+}
+
+type traceKey struct{}
+
+func ContextWithTrace(ctx context.Context, trace TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	trace, ok := ctx.Value(traceKey{}).(TraceContext)
+	return trace, ok
+}
+
+// injectTrace records a child of the caller's span in the envelope headers.
+func injectTrace(ctx context.Context, envelope *Envelope) {
+	trace, ok := TraceFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	if envelope.Headers == nil {
+		envelope.Headers = make(map[string]string)
+	}
+	envelope.Headers[HeaderTraceParent] = trace.Child().TraceParent()
+	if trace.State != "" {
+		envelope.Headers[HeaderTraceState] = trace.State
+	}
+}
+
+// extractTrace starts the consumer span as a child of the producer span
+// carried in the message headers.
+func extractTrace(ctx context.Context, metadata Metadata) context.Context {
+	header, ok := metadata.Headers[HeaderTraceParent]
+	if !ok {
+		return ctx
+	}
+
+	trace, err := ParseTraceParent(header)
+	if err != nil {
+		return ctx
+	}
+	trace.State = metadata.Headers[HeaderTraceState]
+
+	return ContextWithTrace(ctx, trace.Child())
+}
+
+func isHex(s string, size int) bool {
+	if len(s) != 2*size || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func randomHex(size int) string {
+	buf := make([]byte, size)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
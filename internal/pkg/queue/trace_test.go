@@ -0,0 +1,114 @@
+package queue_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/queue"
+)
+
+var _ = Describe("Trace context", func() {
+	It("should round trip a traceparent header", func() {
+		trace, err := queue.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trace.TraceID).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(trace.SpanID).To(Equal("00f067aa0ba902b7"))
+		Expect(trace.Sampled).To(BeTrue())
+		Expect(trace.TraceParent()).To(Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	})
+
+	It("should accept headers from future versions", func() {
+		trace, err := queue.ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trace.Sampled).To(BeFalse())
+	})
+
+	DescribeTable("should reject invalid headers",
+		func(header string) {
+			_, err := queue.ParseTraceParent(header)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("wrong field count", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"),
+		Entry("invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+		Entry("zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"),
+		Entry("zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"),
+		Entry("uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"),
+		Entry("trailing data in version 00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"),
+	)
+
+	It("should create child spans in the same trace", func() {
+		parent := queue.NewTraceContext()
+		child := parent.Child()
+
+		Expect(child.TraceID).To(Equal(parent.TraceID))
+		Expect(child.SpanID).NotTo(Equal(parent.SpanID))
+		Expect(child.TraceID).To(HaveLen(32))
+		Expect(child.SpanID).To(HaveLen(16))
+	})
+
+	Describe("propagation through a queue", func() {
+		var (
+			q      *queue.MemoryQueue[string]
+			ctx    context.Context
+			cancel context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			q = queue.NewMemoryQueue[string]("traced")
+			ctx, cancel = context.WithCancel(context.Background())
+		})
+
+		AfterEach(func() {
+			cancel()
+			Expect(q.Close()).To(Succeed())
+		})
+
+		type delivery struct {
+			trace    queue.TraceContext
+			traced   bool
+			metadata queue.Metadata
+		}
+
+		consume := func() delivery {
+			deliveries := make(chan delivery, 1)
+			go func() {
+				_ = q.Consume(ctx, func(handlerCtx context.Context, _ string) error {
+					trace, ok := queue.TraceFromContext(handlerCtx)
+					metadata, _ := queue.MetadataFromContext(handlerCtx)
+					deliveries <- delivery{trace: trace, traced: ok, metadata: metadata}
+					return nil
+				})
+			}()
+
+			var d delivery
+			Eventually(deliveries).Should(Receive(&d))
+			return d
+		}
+
+		It("should continue the producer trace in the handler context", func() {
+			producer := queue.NewTraceContext()
+			producer.State = "vendor=value"
+			Expect(q.Publish(queue.ContextWithTrace(ctx, producer), "hello", nil)).To(Succeed())
+
+			d := consume()
+			Expect(d.metadata.Headers).To(HaveKey(queue.HeaderTraceParent))
+			Expect(d.metadata.Headers).To(HaveKeyWithValue(queue.HeaderTraceState, "vendor=value"))
+
+			Expect(d.traced).To(BeTrue())
+			Expect(d.trace.TraceID).To(Equal(producer.TraceID))
+			Expect(d.trace.SpanID).NotTo(Equal(producer.SpanID))
+			Expect(d.trace.State).To(Equal("vendor=value"))
+		})
+
+		It("should not invent a trace when the producer had none", func() {
+			Expect(q.Publish(ctx, "hello", nil)).To(Succeed())
+
+			d := consume()
+			Expect(d.traced).To(BeFalse())
+			Expect(d.metadata.Headers).NotTo(HaveKey(queue.HeaderTraceParent))
+		})
+	})
+})
@@ -0,0 +1,36 @@
+package redisconn
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewClient connects to Redis as described by config and checks the
+// connection. The client is safe to share between queues and caches.
+func NewClient(config *Config) (redis.UniversalClient, error) {
+	options, err := config.UniversalOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewUniversalClient(options)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+
+	return client, nil
+}
+
+func NewClientFromEnv() (redis.UniversalClient, error) {
+	return NewClient(ConfigFromEnv())
+}
+
+// IsCluster reports whether keys of client are spread over cluster slots,
+// in which case keys used together must share a hash tag.
+func IsCluster(client redis.UniversalClient) bool {
+	_, ok := client.(*redis.ClusterClient)
+	return ok
+}
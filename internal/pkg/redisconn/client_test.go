@@ -0,0 +1,52 @@
+package redisconn_test
+
+import (
+	"context"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/redis/go-redis/v9"
+
+	"mmm-osint/internal/pkg/redisconn"
+)
+
+var _ = Describe("NewClient", func() {
+	It("should connect to a single node", func() {
+		server := miniredis.RunT(GinkgoT())
+		server.RequireAuth("secret")
+
+		client, err := redisconn.NewClient(&redisconn.Config{
+			Addrs:    []string{server.Addr()},
+			Password: "secret",
+			PoolSize: 5,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer client.Close()
+
+		Expect(client.Set(context.Background(), "key", "value", 0).Err()).To(Succeed())
+		Expect(server.Get("key")).To(Equal("value"))
+		Expect(redisconn.IsCluster(client)).To(BeFalse())
+	})
+
+	It("should fail when Redis is unreachable", func() {
+		server := miniredis.RunT(GinkgoT())
+		addr := server.Addr()
+		server.Close()
+
+		_, err := redisconn.NewClient(&redisconn.Config{Addrs: []string{addr}})
+		Expect(err).To(MatchError(ContainSubstring("failed to connect to Redis")))
+	})
+
+	It("should fail on an invalid configuration", func() {
+		_, err := redisconn.NewClient(&redisconn.Config{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should recognise cluster clients", func() {
+		client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:0"}})
+		defer client.Close()
+
+		Expect(redisconn.IsCluster(client)).To(BeTrue())
+	})
+})
@@ -0,0 +1,134 @@
+package redisconn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"mmm-osint/internal/pkg/env"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Config describes how to reach Redis. A single address connects to one
+// node, MasterName switches to Sentinel with Addrs as the sentinels, and
+// several addresses or Cluster connect to a Redis Cluster.
+type Config struct {
+	Addrs            []string
+	Username         string
+	Password         string
+	DB               int
+	MasterName       string
+	SentinelUsername string
+	SentinelPassword string
+	Cluster          bool
+
+	TLS                   bool
+	TLSServerName         string
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSInsecureSkipVerify bool
+
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+func ConfigFromEnv() *Config {
+	return &Config{
+		Addrs:            env.GetListOrDefault("REDIS_URI", []string{"localhost:6379"}),
+		Username:         env.GetOrDefault("REDIS_USERNAME", ""),
+		Password:         env.GetOrDefault("REDIS_PASSWORD", ""),
+		DB:               env.GetIntOrDefault("REDIS_DB", 0),
+		MasterName:       env.GetOrDefault("REDIS_SENTINEL_MASTER", ""),
+		SentinelUsername: env.GetOrDefault("REDIS_SENTINEL_USERNAME", ""),
+		SentinelPassword: env.GetOrDefault("REDIS_SENTINEL_PASSWORD", ""),
+		Cluster:          env.GetBoolOrDefault("REDIS_CLUSTER", false),
+
+		TLS:                   env.GetBoolOrDefault("REDIS_TLS", false),
+		TLSServerName:         env.GetOrDefault("REDIS_TLS_SERVER_NAME", ""),
+		TLSCAFile:             env.GetOrDefault("REDIS_TLS_CA_FILE", ""),
+		TLSCertFile:           env.GetOrDefault("REDIS_TLS_CERT_FILE", ""),
+		TLSKeyFile:            env.GetOrDefault("REDIS_TLS_KEY_FILE", ""),
+		TLSInsecureSkipVerify: env.GetBoolOrDefault("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+
+		PoolSize:     env.GetIntOrDefault("REDIS_POOL_SIZE", 0),
+		MinIdleConns: env.GetIntOrDefault("REDIS_MIN_IDLE_CONNS", 0),
+		DialTimeout:  env.GetDurationOrDefault("REDIS_DIAL_TIMEOUT", 0),
+		ReadTimeout:  env.GetDurationOrDefault("REDIS_READ_TIMEOUT", 0),
+		WriteTimeout: env.GetDurationOrDefault("REDIS_WRITE_TIMEOUT", 0),
+	}
+}
+
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	if !c.TLS {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.TLSServerName,
+		InsecureSkipVerify: c.TLSInsecureSkipVerify,
+	}
+
+	if c.TLSCAFile != "" {
+		pem, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Redis CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in Redis CA file: %s", c.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Redis client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (c *Config) UniversalOptions() (*redis.UniversalOptions, error) {
+	if len(c.Addrs) == 0 {
+		return nil, fmt.Errorf("no Redis address configured")
+	}
+	if c.Cluster && c.MasterName != "" {
+		return nil, fmt.Errorf("Redis Cluster and Sentinel cannot be combined")
+	}
+	if (c.Cluster || len(c.Addrs) > 1 && c.MasterName == "") && c.DB != 0 {
+		return nil, fmt.Errorf("Redis Cluster only supports DB 0")
+	}
+
+	tlsConfig, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &redis.UniversalOptions{
+		Addrs:            c.Addrs,
+		Username:         c.Username,
+		Password:         c.Password,
+		DB:               c.DB,
+		MasterName:       c.MasterName,
+		SentinelUsername: c.SentinelUsername,
+		SentinelPassword: c.SentinelPassword,
+		IsClusterMode:    c.Cluster,
+		TLSConfig:        tlsConfig,
+		PoolSize:         c.PoolSize,
+		MinIdleConns:     c.MinIdleConns,
+		DialTimeout:      c.DialTimeout,
+		ReadTimeout:      c.ReadTimeout,
+		WriteTimeout:     c.WriteTimeout,
+	}, nil
+}
@@ -0,0 +1,138 @@
+package redisconn_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/redisconn"
+)
+
+var _ = Describe("Config", func() {
+	Describe("ConfigFromEnv", func() {
+		It("should default to a single local node", func() {
+			config := redisconn.ConfigFromEnv()
+
+			Expect(config.Addrs).To(Equal([]string{"localhost:6379"}))
+			Expect(config.DB).To(Equal(0))
+			Expect(config.TLS).To(BeFalse())
+		})
+
+		It("should read every setting from the environment", func() {
+			vars := map[string]string{
+				"REDIS_URI":               "sentinel-1:26379, sentinel-2:26379",
+				"REDIS_USERNAME":          "osint",
+				"REDIS_PASSWORD":          "secret",
+				"REDIS_DB":                "3",
+				"REDIS_SENTINEL_MASTER":   "mymaster",
+				"REDIS_SENTINEL_PASSWORD": "sentinel-secret",
+				"REDIS_TLS":               "true",
+				"REDIS_TLS_SERVER_NAME":   "redis.internal",
+				"REDIS_POOL_SIZE":         "50",
+				"REDIS_MIN_IDLE_CONNS":    "5",
+				"REDIS_DIAL_TIMEOUT":      "2s",
+				"REDIS_READ_TIMEOUT":      "500ms",
+			}
+			for key, value := range vars {
+				os.Setenv(key, value)
+			}
+			DeferCleanup(func() {
+				for key := range vars {
+					os.Unsetenv(key)
+				}
+			})
+
+			config := redisconn.ConfigFromEnv()
+
+			Expect(config.Addrs).To(Equal([]string{"sentinel-1:26379", "sentinel-2:26379"}))
+			Expect(config.Username).To(Equal("osint"))
+			Expect(config.Password).To(Equal("secret"))
+			Expect(config.DB).To(Equal(3))
+			Expect(config.MasterName).To(Equal("mymaster"))
+			Expect(config.SentinelPassword).To(Equal("sentinel-secret"))
+			Expect(config.TLS).To(BeTrue())
+			Expect(config.TLSServerName).To(Equal("redis.internal"))
+			Expect(config.PoolSize).To(Equal(50))
+			Expect(config.MinIdleConns).To(Equal(5))
+			Expect(config.DialTimeout).To(Equal(2 * time.Second))
+			Expect(config.ReadTimeout).To(Equal(500 * time.Millisecond))
+		})
+	})
+
+	Describe("UniversalOptions", func() {
+		It("should pass the settings through", func() {
+			config := &redisconn.Config{
+				Addrs:       []string{"redis:6379"},
+				Username:    "osint",
+				Password:    "secret",
+				DB:          2,
+				PoolSize:    20,
+				ReadTimeout: time.Second,
+			}
+
+			options, err := config.UniversalOptions()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(options.Addrs).To(Equal([]string{"redis:6379"}))
+			Expect(options.Username).To(Equal("osint"))
+			Expect(options.DB).To(Equal(2))
+			Expect(options.PoolSize).To(Equal(20))
+			Expect(options.ReadTimeout).To(Equal(time.Second))
+			Expect(options.TLSConfig).To(BeNil())
+		})
+
+		It("should require an address", func() {
+			_, err := (&redisconn.Config{}).UniversalOptions()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject a database other than 0 on a cluster", func() {
+			_, err := (&redisconn.Config{Addrs: []string{"a:6379", "b:6379"}, DB: 1}).UniversalOptions()
+			Expect(err).To(HaveOccurred())
+
+			_, err = (&redisconn.Config{Addrs: []string{"a:6379"}, Cluster: true, DB: 1}).UniversalOptions()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should allow a database with several sentinels", func() {
+			options, err := (&redisconn.Config{Addrs: []string{"a:26379", "b:26379"}, MasterName: "mymaster", DB: 1}).UniversalOptions()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(options.MasterName).To(Equal("mymaster"))
+		})
+
+		It("should reject combining cluster and sentinel", func() {
+			_, err := (&redisconn.Config{Addrs: []string{"a:6379"}, Cluster: true, MasterName: "mymaster"}).UniversalOptions()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("TLSConfig", func() {
+		It("should be nil when TLS is disabled", func() {
+			tlsConfig, err := (&redisconn.Config{}).TLSConfig()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tlsConfig).To(BeNil())
+		})
+
+		It("should set the server name and verification", func() {
+			tlsConfig, err := (&redisconn.Config{TLS: true, TLSServerName: "redis.internal", TLSInsecureSkipVerify: true}).TLSConfig()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tlsConfig.ServerName).To(Equal("redis.internal"))
+			Expect(tlsConfig.InsecureSkipVerify).To(BeTrue())
+		})
+
+		It("should fail on a missing CA file", func() {
+			_, err := (&redisconn.Config{TLS: true, TLSCAFile: filepath.Join(GinkgoT().TempDir(), "missing.pem")}).TLSConfig()
+			Expect(err).To(MatchError(ContainSubstring("failed to read Redis CA file")))
+		})
+
+		It("should fail on a CA file without certificates", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "empty.pem")
+			Expect(os.WriteFile(path, []byte("not a certificate"), 0o600)).To(Succeed())
+
+			_, err := (&redisconn.Config{TLS: true, TLSCAFile: path}).TLSConfig()
+			Expect(err).To(MatchError(ContainSubstring("no certificates found")))
+		})
+	})
+})
@@ -0,0 +1,13 @@
+package redisconn_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRedisconn(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Redisconn Suite")
+}
@@ -9,6 +9,8 @@ import (
 
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/debug"
+
+	"mmm-osint/internal/pkg/cache"
 )
 
 type CollyScraper struct {
@@ -56,6 +58,14 @@ func (cs *CollyScraper) SetTimeout(timeout time.Duration) {
 	cs.collector.SetRequestTimeout(timeout)
 }
 
+// SetCache keeps raw responses in c, so pages scraped again are served from
+// it while fresh and revalidated with the server otherwise. Scraping a page
+// again is allowed once a cache is set.
+func (cs *CollyScraper) SetCache(c cache.Cache) {
+	cs.collector.WithTransport(NewHTTPCache(c, nil, nil))
+	cs.collector.AllowURLRevisit = true
+}
+
 func (cs *CollyScraper) Close() error {
 	return nil
 }
@@ -192,6 +202,12 @@ func (cs *CollyScraper) configureCollector(c *colly.Collector, options *Scraping
 		})
 	}
 
+	if options.ForceRefresh {
+		c.OnRequest(func(r *colly.Request) {
+			r.Headers.Set("Cache-Control", "no-cache")
+		})
+	}
+
 	if !options.FollowRedirects {
 		c.OnResponse(func(r *colly.Response) {
 			if r.StatusCode >= 300 && r.StatusCode < 400 {
@@ -0,0 +1,323 @@
+package webpage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mmm-osint/internal/pkg/cache"
+)
+
+const (
+	// CacheStatusHeader is added to responses that went through an HTTPCache.
+	CacheStatusHeader = "X-Cache-Status"
+
+	// CacheHit means the response was fresh and served from the cache.
+	CacheHit = "HIT"
+	// CacheRevalidated means the server confirmed the cached response.
+	CacheRevalidated = "REVALIDATED"
+	// CacheMiss means the response came from the server.
+	CacheMiss = "MISS"
+
+	httpCacheKeyPrefix = "http:"
+)
+
+type HTTPCacheOptions struct {
+	// TTL is how long a response that can be revalidated is kept once it is
+	// stale. It defaults to a week.
+	TTL time.Duration
+}
+
+func (o *HTTPCacheOptions) withDefaults() HTTPCacheOptions {
+	options := HTTPCacheOptions{
+		TTL: 7 * 24 * time.Hour,
+	}
+	if o != nil && o.TTL > 0 {
+		options.TTL = o.TTL
+	}
+	return options
+}
+
+// cachedResponse is a raw response as kept in the cache.
+type cachedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// Vary holds the request headers the response varies on.
+	Vary       http.Header `json:"vary,omitempty"`
+	StoredAt   time.Time   `json:"stored_at"`
+	FreshUntil time.Time   `json:"fresh_until"`
+}
+
+func (c *cachedResponse) response(req *http.Request, status string) *http.Response {
+	header := c.Header.Clone()
+	header.Set(CacheStatusHeader, status)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", c.StatusCode, http.StatusText(c.StatusCode)),
+		StatusCode:    c.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// HTTPCache is an http.RoundTripper keeping GET responses in a cache.Cache.
+// Fresh responses are served without a request, stale ones are revalidated
+// with If-None-Match and If-Modified-Since. A request with Cache-Control:
+// no-cache skips the cached response but stores the new one.
+//
+// The cache is shared by every request for a URL, so responses marked
+// private or setting cookies are not stored, nor are responses to requests
+// carrying Authorization or Cookie unless marked public or s-maxage.
+type HTTPCache struct {
+	cache     cache.Cache
+	transport http.RoundTripper
+	options   HTTPCacheOptions
+}
+
+// NewHTTPCache sends requests through transport, or http.DefaultTransport
+// when it is nil.
+func NewHTTPCache(c cache.Cache, transport http.RoundTripper, options *HTTPCacheOptions) *HTTPCache {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &HTTPCache{
+		cache:     c,
+		transport: transport,
+		options:   options.withDefaults(),
+	}
+}
+
+func (h *HTTPCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Range and conditional requests expect answers the cache does not keep.
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" ||
+		req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return h.transport.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	key := httpCacheKeyPrefix + req.URL.String()
+
+	var stored *cachedResponse
+	if _, noCache := cacheControl(req.Header)["no-cache"]; !noCache {
+		stored = h.lookup(ctx, key, req)
+	}
+	if stored != nil && time.Now().Before(stored.FreshUntil) {
+		return stored.response(req, CacheHit), nil
+	}
+
+	outgoing := req
+	if stored != nil {
+		outgoing = req.Clone(ctx)
+		if etag := stored.Header.Get("ETag"); etag != "" {
+			outgoing.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := stored.Header.Get("Last-Modified"); lastModified != "" {
+			outgoing.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := h.transport.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		stored.refresh(resp.Header, time.Now())
+		h.store(ctx, key, stored)
+		return stored.response(req, CacheRevalidated), nil
+	}
+
+	return h.storeResponse(ctx, key, req, resp)
+}
+
+func (h *HTTPCache) lookup(ctx context.Context, key string, req *http.Request) *cachedResponse {
+	var stored cachedResponse
+	if err := h.cache.Get(ctx, key, &stored); err != nil {
+		if !errors.Is(err, cache.ErrKeyNotFound) {
+			log.Printf("Error reading cached response for %s: %v", req.URL, err)
+		}
+		return nil
+	}
+
+	for name := range stored.Vary {
+		if req.Header.Get(name) != stored.Vary.Get(name) {
+			return nil
+		}
+	}
+	return &stored
+}
+
+func (h *HTTPCache) storeResponse(ctx context.Context, key string, req *http.Request, resp *http.Response) (*http.Response, error) {
+	now := time.Now()
+	if !cacheable(req, resp) {
+		resp.Header.Set(CacheStatusHeader, CacheMiss)
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	stored := &cachedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       body,
+		Vary:       varyHeaders(req, resp.Header),
+		StoredAt:   now,
+		FreshUntil: now.Add(freshness(resp.Header, now)),
+	}
+	h.store(ctx, key, stored)
+
+	resp.Header.Set(CacheStatusHeader, CacheMiss)
+	return resp, nil
+}
+
+// store keeps a response as long as it is fresh, or for the TTL past that
+// when it can be revalidated. Failing to cache never fails the request.
+func (h *HTTPCache) store(ctx context.Context, key string, stored *cachedResponse) {
+	ttl := time.Until(stored.FreshUntil)
+	if hasValidators(stored.Header) {
+		ttl = max(ttl, 0) + h.options.TTL
+	}
+	// Cookies are meant for the client that was sent them.
+	if ttl <= 0 || stored.Header.Get("Set-Cookie") != "" {
+		return
+	}
+
+	if err := h.cache.Set(ctx, key, stored, ttl); err != nil {
+		log.Printf("Error caching response for %s: %v", strings.TrimPrefix(key, httpCacheKeyPrefix), err)
+	}
+}
+
+// refresh applies the headers of a 304 response to the cached one.
+func (c *cachedResponse) refresh(header http.Header, now time.Time) {
+	for name, values := range header {
+		// The length is that of the empty 304 body.
+		if name != "Content-Length" {
+			c.Header[name] = values
+		}
+	}
+	c.StoredAt = now
+	c.FreshUntil = now.Add(freshness(c.Header, now))
+}
+
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+func cacheable(req *http.Request, resp *http.Response) bool {
+	if !cacheableStatuses[resp.StatusCode] || resp.Header.Get("Vary") == "*" {
+		return false
+	}
+	directives := cacheControl(resp.Header)
+	if _, noStore := directives["no-store"]; noStore {
+		return false
+	}
+	if _, private := directives["private"]; private {
+		return false
+	}
+	// RFC 9111 section 3.5 keeps responses to authorized requests out of
+	// shared caches unless the server allows it.
+	if req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "" {
+		_, public := directives["public"]
+		_, sMaxAge := directives["s-maxage"]
+		if !public && !sMaxAge {
+			return false
+		}
+	}
+	return hasValidators(resp.Header) || freshness(resp.Header, time.Now()) > 0
+}
+
+func hasValidators(header http.Header) bool {
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+}
+
+// freshness is how long a response stays fresh from now, following
+// Cache-Control, then Expires, then a tenth of the time since Last-Modified
+// as RFC 9111 suggests for responses without explicit freshness.
+func freshness(header http.Header, now time.Time) time.Duration {
+	directives := cacheControl(header)
+	if _, noCache := directives["no-cache"]; noCache {
+		return 0
+	}
+
+	date := now
+	if parsed, err := http.ParseTime(header.Get("Date")); err == nil {
+		date = parsed
+	}
+
+	if maxAge, ok := directives["max-age"]; ok {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil {
+			return 0
+		}
+		age, _ := strconv.Atoi(header.Get("Age"))
+		return time.Duration(seconds-age) * time.Second
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		parsed, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return parsed.Sub(date)
+	}
+
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		return date.Sub(lastModified) / 10
+	}
+	return 0
+}
+
+// cacheControl parses the Cache-Control directives of header. Directives
+// without a value map to an empty string.
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+func varyHeaders(req *http.Request, header http.Header) http.Header {
+	var vary http.Header
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				if vary == nil {
+					vary = make(http.Header)
+				}
+				vary.Set(name, req.Header.Get(name))
+			}
+		}
+	}
+	return vary
+}
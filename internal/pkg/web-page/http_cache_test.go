@@ -0,0 +1,244 @@
+package webpage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"mmm-osint/internal/pkg/cache"
+)
+
+var _ = Describe("HTTPCache", func() {
+	var (
+		server   *httptest.Server
+		requests atomic.Int32
+		handler  http.HandlerFunc
+		client   *http.Client
+		lruCache *cache.LRUCache
+	)
+
+	BeforeEach(func() {
+		requests.Store(0)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			handler(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		var err error
+		lruCache, err = cache.NewLRUCache(100)
+		Expect(err).NotTo(HaveOccurred())
+		client = &http.Client{Transport: NewHTTPCache(lruCache, nil, nil)}
+	})
+
+	get := func(header ...string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+
+		resp, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return resp, string(body)
+	}
+
+	It("should serve fresh responses from the cache", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("page"))
+		}
+
+		resp, body := get()
+		Expect(resp.Header.Get(CacheStatusHeader)).To(Equal(CacheMiss))
+		Expect(body).To(Equal("page"))
+
+		resp, body = get()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get(CacheStatusHeader)).To(Equal(CacheHit))
+		Expect(resp.Header.Get("Cache-Control")).To(Equal("max-age=60"))
+		Expect(body).To(Equal("page"))
+		Expect(requests.Load()).To(Equal(int32(1)))
+	})
+
+	It("should revalidate stale responses with their ETag", func() {
+		var conditional atomic.Int32
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				conditional.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte("page"))
+		}
+
+		get()
+		resp, body := get()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get(CacheStatusHeader)).To(Equal(CacheRevalidated))
+		Expect(body).To(Equal("page"))
+		Expect(requests.Load()).To(Equal(int32(2)))
+		Expect(conditional.Load()).To(Equal(int32(1)))
+	})
+
+	It("should revalidate with Last-Modified and replace changed responses", func() {
+		lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+		version := "v1"
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("Last-Modified", lastModified)
+			if version == "v1" && r.Header.Get("If-Modified-Since") == lastModified {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte(version))
+		}
+
+		get()
+		resp, body := get()
+		Expect(resp.Header.Get(CacheStatusHeader)).To(Equal(CacheRevalidated))
+		Expect(body).To(Equal("v1"))
+
+		version = "v2"
+		resp, body = get()
+		Expect(resp.Header.Get(CacheStatusHeader)).To(Equal(CacheMiss))
+		Expect(body).To(Equal("v2"))
+	})
+
+	It("should refetch requests sent with Cache-Control: no-cache", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get("If-None-Match")).To(BeEmpty())
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte("page"))
+		}
+
+		get()
+		resp, _ := get("Cache-Control", "no-cache")
+		Expect(resp.Header.Get(CacheStatusHeader)).To(Equal(CacheMiss))
+		Expect(requests.Load()).To(Equal(int32(2)))
+
+		resp, _ = get()
+		Expect(resp.Header.Get(CacheStatusHeader)).To(Equal(CacheHit))
+	})
+
+	It("should not store responses marked no-store or without freshness or validators", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("plain") == "" {
+				w.Header().Set("Cache-Control", "no-store")
+				w.Header().Set("ETag", `"v1"`)
+			}
+			w.Write([]byte("page"))
+		}
+
+		get()
+		get()
+		Expect(requests.Load()).To(Equal(int32(2)))
+		Expect(lruCache.Stats().Entries).To(BeZero())
+
+		resp, err := client.Get(server.URL + "?plain=1")
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(lruCache.Stats().Entries).To(BeZero())
+	})
+
+	It("should not store responses setting cookies", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+			w.Write([]byte("page"))
+		}
+
+		get()
+		resp, _ := get()
+		Expect(resp.Header.Get(CacheStatusHeader)).To(Equal(CacheMiss))
+		Expect(requests.Load()).To(Equal(int32(2)))
+		Expect(lruCache.Stats().Entries).To(BeZero())
+	})
+
+	It("should not store responses marked private", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "private, max-age=60")
+			w.Write([]byte("page"))
+		}
+
+		get()
+		resp, _ := get()
+		Expect(resp.Header.Get(CacheStatusHeader)).To(Equal(CacheMiss))
+		Expect(requests.Load()).To(Equal(int32(2)))
+		Expect(lruCache.Stats().Entries).To(BeZero())
+	})
+
+	It("should only store responses to requests with credentials when marked public", func() {
+		cacheControl := "max-age=60"
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", cacheControl)
+			w.Write([]byte("page"))
+		}
+
+		get("Authorization", "Bearer token")
+		get("Cookie", "session=secret")
+		Expect(lruCache.Stats().Entries).To(BeZero())
+
+		cacheControl = "public, max-age=60"
+		get("Authorization", "Bearer token")
+		resp, _ := get()
+		Expect(resp.Header.Get(CacheStatusHeader)).To(Equal(CacheHit))
+		Expect(requests.Load()).To(Equal(int32(3)))
+	})
+
+	It("should keep responses apart by the headers they vary on", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			w.Write([]byte(r.Header.Get("Accept-Language")))
+		}
+
+		_, body := get("Accept-Language", "fr")
+		Expect(body).To(Equal("fr"))
+		_, body = get("Accept-Language", "en")
+		Expect(body).To(Equal("en"))
+		resp, body := get("Accept-Language", "en")
+		Expect(resp.Header.Get(CacheStatusHeader)).To(Equal(CacheHit))
+		Expect(body).To(Equal("en"))
+	})
+
+	Describe("with the scraper", func() {
+		It("should scrape cached pages again and refresh them on demand", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Write([]byte("<html><head><title>Cached</title></head><body>Hello</body></html>"))
+			}
+
+			scraper := NewCollyScraper()
+			scraper.SetCache(lruCache)
+			options := DefaultScrapingOptions()
+			options.RateLimitDelay = 0
+
+			for range 2 {
+				result, err := scraper.Scrape(context.Background(), server.URL, options)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Title).To(Equal("Cached"))
+			}
+			Expect(requests.Load()).To(Equal(int32(1)))
+
+			options.ForceRefresh = true
+			result, err := scraper.Scrape(context.Background(), server.URL, options)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Headers[CacheStatusHeader]).To(Equal(CacheMiss))
+			Expect(requests.Load()).To(Equal(int32(2)))
+		})
+	})
+})
@@ -76,6 +76,9 @@ type ScrapingOptions struct {
 	ExtractForms      bool          `json:"extract_forms"`
 	ExtractScripts    bool          `json:"extract_scripts"`
 	ExtractMeta       bool          `json:"extract_meta"`
+	// ForceRefresh fetches the page even when the scraper has a fresh copy
+	// in its cache. The new response replaces the cached one.
+	ForceRefresh bool `json:"force_refresh"`
 }
 
 func DefaultScrapingOptions() *ScrapingOptions {